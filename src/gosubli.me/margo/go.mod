@@ -13,6 +13,7 @@ require (
 	github.com/stretchr/testify v1.7.2
 	go.uber.org/multierr v1.6.0
 	go.uber.org/zap v1.21.0
+	golang.org/x/mod v0.21.0
 	golang.org/x/sync v0.8.0
 	golang.org/x/sys v0.26.0
 	golang.org/x/tools v0.26.0
@@ -24,7 +25,6 @@ require (
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
-	golang.org/x/mod v0.21.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
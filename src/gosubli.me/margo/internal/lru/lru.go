@@ -132,12 +132,27 @@ func (c *Cache) removeElement(e *list.Element) {
 // Len returns the number of items in the cache.
 func (c *Cache) Len() int {
 	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.cache == nil {
 		return 0
 	}
-	n := c.ll.Len()
-	c.mu.Unlock()
-	return n
+	return c.ll.Len()
+}
+
+// Range calls fn for each item in the cache from most to least recently
+// used, stopping if fn returns false. The cache must not be modified by fn.
+func (c *Cache) Range(fn func(key Key, value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return
+	}
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		kv := e.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
 }
 
 // Clear purges all stored items from the cache.
@@ -153,6 +153,30 @@ func TestRemoveFunc(t *testing.T) {
 	}
 }
 
+func TestRange(t *testing.T) {
+	lru := New(0)
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	lru.Add("c", 3)
+	var keys []Key
+	lru.Range(func(key Key, val interface{}) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if len(keys) != 2 || keys[0] != Key("c") || keys[1] != Key("b") {
+		t.Errorf("got keys %v; want: [c b]", keys)
+	}
+	lru.Clear()
+	if n := lru.Len(); n != 0 {
+		t.Errorf("Len() = %d after Clear; want: 0", n)
+	}
+	lru.Range(func(key Key, val interface{}) bool {
+		t.Errorf("unexpected key after Clear: %v", key)
+		return true
+	})
+	lru.Add("d", 4) // must not deadlock
+}
+
 func TestParallelStress(t *testing.T) {
 	const N = 1024
 	lru := New(1024)
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// SessionBuildConfig is the build configuration of a workspace that is used
+// by every method that creates a build.Context. Empty fields leave the value
+// of the environment unchanged.
+type SessionBuildConfig struct {
+	// Workspace is the root directory the config applies to, if empty the
+	// config applies to all files not in a configured workspace.
+	Workspace  string   `json:"workspace"`
+	GOOS       string   `json:"goos,omitempty"`
+	GOARCH     string   `json:"goarch,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	CgoEnabled *bool    `json:"cgo_enabled,omitempty"`
+}
+
+// apply updates ctxt with the config.
+func (c *SessionBuildConfig) apply(ctxt *build.Context) {
+	if c.GOOS != "" {
+		ctxt.GOOS = c.GOOS
+	}
+	if c.GOARCH != "" {
+		ctxt.GOARCH = c.GOARCH
+	}
+	if c.CgoEnabled != nil {
+		ctxt.CgoEnabled = *c.CgoEnabled
+	}
+	for _, tag := range c.Tags {
+		if !stringsContain(ctxt.BuildTags, tag) {
+			ctxt.BuildTags = append(ctxt.BuildTags, tag)
+		}
+	}
+}
+
+var buildConfigs struct {
+	sync.RWMutex
+	m map[string]*SessionBuildConfig // workspace => config
+}
+
+// activeBuildConfig returns the config of the innermost workspace containing
+// path or nil if there is none.
+func activeBuildConfig(path string) *SessionBuildConfig {
+	buildConfigs.RLock()
+	defer buildConfigs.RUnlock()
+	var conf *SessionBuildConfig
+	for dir, c := range buildConfigs.m {
+		if dir == "" || path == "" || !hasPathPrefix(path, dir) {
+			continue
+		}
+		if conf == nil || len(dir) > len(conf.Workspace) {
+			conf = c
+		}
+	}
+	if conf == nil {
+		conf = buildConfigs.m[""]
+	}
+	return conf
+}
+
+// sessionBuildConfigs returns the configs sorted by workspace.
+func sessionBuildConfigs() []*SessionBuildConfig {
+	buildConfigs.RLock()
+	a := make([]*SessionBuildConfig, 0, len(buildConfigs.m))
+	for _, c := range buildConfigs.m {
+		a = append(a, c)
+	}
+	buildConfigs.RUnlock()
+	sort.Slice(a, func(i, j int) bool {
+		return a[i].Workspace < a[j].Workspace
+	})
+	return a
+}
+
+// SetBuildConfigRequest sets, or clears, the build configuration of a
+// workspace.
+type SetBuildConfigRequest struct {
+	SessionBuildConfig
+	// Clear removes the config of the workspace.
+	Clear bool `json:"clear"`
+}
+
+type SetBuildConfigResponse struct {
+	Configs []*SessionBuildConfig `json:"configs"`
+}
+
+func (r *SetBuildConfigRequest) Call() (interface{}, string) {
+	conf := r.SessionBuildConfig
+	if conf.Workspace != "" {
+		conf.Workspace = filepath.Clean(conf.Workspace)
+	}
+	conf.Tags = strings.Fields(strings.Join(conf.Tags, " "))
+
+	buildConfigs.Lock()
+	if r.Clear {
+		delete(buildConfigs.m, conf.Workspace)
+	} else {
+		if buildConfigs.m == nil {
+			buildConfigs.m = make(map[string]*SessionBuildConfig)
+		}
+		buildConfigs.m[conf.Workspace] = &conf
+	}
+	buildConfigs.Unlock()
+
+	// cached results were computed with the previous config
+	compLintCache.Clear()
+
+	logger.Named("set_build_config").Info("build config", zap.String("workspace", conf.Workspace),
+		zap.String("goos", conf.GOOS), zap.String("goarch", conf.GOARCH),
+		zap.Strings("tags", conf.Tags), zap.Bool("clear", r.Clear))
+
+	return &SetBuildConfigResponse{Configs: sessionBuildConfigs()}, ""
+}
+
+func init() {
+	registry.Register("set_build_config", func(_ *Broker) Caller {
+		return &SetBuildConfigRequest{}
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetBuildConfig(t *testing.T) {
+	dir := t.TempDir()
+	inner := filepath.Join(dir, "inner")
+	t.Cleanup(func() {
+		for _, ws := range []string{dir, inner} {
+			(&SetBuildConfigRequest{SessionBuildConfig: SessionBuildConfig{Workspace: ws}, Clear: true}).Call()
+		}
+	})
+
+	goos := "windows"
+	if build.Default.GOOS == goos {
+		goos = "plan9"
+	}
+	disabled := false
+	set := func(conf SessionBuildConfig) {
+		t.Helper()
+		if _, err := (&SetBuildConfigRequest{SessionBuildConfig: conf}).Call(); err != "" {
+			t.Fatal(err)
+		}
+	}
+	set(SessionBuildConfig{
+		Workspace:  dir,
+		GOOS:       goos,
+		Tags:       []string{"foo bar"},
+		CgoEnabled: &disabled,
+	})
+	set(SessionBuildConfig{Workspace: inner, GOARCH: "arm64"})
+
+	ctxt := contextFromEnv(map[string]string{"GOOS": build.Default.GOOS}, filepath.Join(dir, "a.go"))
+	if ctxt.GOOS != goos || ctxt.CgoEnabled {
+		t.Errorf("GOOS = %q CgoEnabled = %t; want: %q false", ctxt.GOOS, ctxt.CgoEnabled, goos)
+	}
+	if !stringsContain(ctxt.BuildTags, "foo") || !stringsContain(ctxt.BuildTags, "bar") {
+		t.Errorf("BuildTags = %q; want: [foo bar]", ctxt.BuildTags)
+	}
+
+	// the innermost workspace is used
+	ctxt = contextFromEnv(nil, filepath.Join(inner, "b.go"))
+	if ctxt.GOARCH != "arm64" || ctxt.GOOS != build.Default.GOOS {
+		t.Errorf("inner: GOOS/GOARCH = %s/%s; want: %s/arm64", ctxt.GOOS, ctxt.GOARCH, build.Default.GOOS)
+	}
+
+	// files outside of the workspace are not affected
+	ctxt = contextFromEnv(nil, filepath.Join(filepath.Dir(dir), "c.go"))
+	if ctxt.GOOS != build.Default.GOOS || len(ctxt.BuildTags) != len(build.Default.BuildTags) {
+		t.Errorf("outside: unexpected context: %s %q", ctxt.GOOS, ctxt.BuildTags)
+	}
+
+	// comp_lint matches the GOOS of the file to the active config
+	ctxt = fileBuildContext(filepath.Join(dir, "d.go"), []byte("package d\n"))
+	if ctxt.GOOS != goos {
+		t.Errorf("fileBuildContext: GOOS = %q; want: %q", ctxt.GOOS, goos)
+	}
+
+	(&SetBuildConfigRequest{SessionBuildConfig: SessionBuildConfig{Workspace: dir}, Clear: true}).Call()
+	if ctxt := contextFromEnv(nil, filepath.Join(dir, "a.go")); ctxt.GOOS != build.Default.GOOS {
+		t.Errorf("cleared: GOOS = %q; want: %q", ctxt.GOOS, build.Default.GOOS)
+	}
+}
+
+func TestContextFromEnvMissingDirs(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	ctxt := contextFromEnv(map[string]string{
+		"GOROOT": missing,
+		"GOPATH": missing + string(filepath.ListSeparator) + dir,
+	}, "")
+	if ctxt.GOROOT != build.Default.GOROOT {
+		t.Errorf("GOROOT = %q; want: %q", ctxt.GOROOT, build.Default.GOROOT)
+	}
+	if ctxt.GOPATH != dir {
+		t.Errorf("GOPATH = %q; want: %q", ctxt.GOPATH, dir)
+	}
+}
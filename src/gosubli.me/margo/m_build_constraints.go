@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charlievieth/buildutil"
+	"go.uber.org/zap"
+)
+
+// BuildConstraintsRequest reports why a file is, or is not, included in the
+// build.
+type BuildConstraintsRequest struct {
+	Filename string            `json:"filename"`
+	Src      string            `json:"src,omitempty"`
+	Env      map[string]string `json:"env"`
+}
+
+type BuildConstraintsResponse struct {
+	Included bool `json:"included"`
+
+	// The build.Context the file was checked against.
+	GOOS         string   `json:"goos"`
+	GOARCH       string   `json:"goarch"`
+	CgoEnabled   bool     `json:"cgo_enabled"`
+	Tags         []string `json:"tags,omitempty"`
+	MgoBuildTags []string `json:"mgo_build_tags,omitempty"`
+
+	// GOOS and GOARCH implied by the filename suffix, if any.
+	FileGOOS   string `json:"file_goos,omitempty"`
+	FileGOARCH string `json:"file_goarch,omitempty"`
+
+	// Constraint is the //go:build (or +build) expression, if any.
+	Constraint string `json:"constraint,omitempty"`
+
+	// Excluded describes the filename suffix or constraint terms that
+	// exclude the file.
+	Excluded []string `json:"excluded,omitempty"`
+
+	// Examples are build configs that include the file.
+	Examples []BuildConfig `json:"examples,omitempty"`
+}
+
+// fileOSArch returns the GOOS and GOARCH of a filename suffix (see
+// buildutil.GoodOSArchFile).
+func fileOSArch(filename string) (goos, goarch string) {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if i := strings.IndexByte(name, '_'); i >= 0 {
+		name = name[i:] // ignore the prefix "x" in "x_linux.go"
+	} else {
+		return "", ""
+	}
+	l := strings.Split(name, "_")
+	if n := len(l); n > 0 && l[n-1] == "test" {
+		l = l[:n-1]
+	}
+	isOS := func(s string) bool { return stringsContain(buildutil.KnownOSList(), s) }
+	isArch := func(s string) bool { return stringsContain(buildutil.KnownArchList(), s) }
+	n := len(l)
+	if n >= 2 && isOS(l[n-2]) && isArch(l[n-1]) {
+		return l[n-2], l[n-1]
+	}
+	if n >= 1 {
+		if isOS(l[n-1]) {
+			return l[n-1], ""
+		}
+		if isArch(l[n-1]) {
+			return "", l[n-1]
+		}
+	}
+	return "", ""
+}
+
+func stringsContain(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// evalTag reports if tag is satisfied by ctxt using the same rules as
+// buildutil (e.g. "unix" and "linux" on android).
+func evalTag(ctxt *build.Context, tag string) bool {
+	return buildutil.NewConstraint(&constraint.TagExpr{Tag: tag}, nil).Eval(ctxt)
+}
+
+// falseTerms returns the terms of expr that cause it to be false for ctxt.
+func falseTerms(ctxt *build.Context, expr constraint.Expr) []string {
+	eval := func(x constraint.Expr) bool {
+		return x.Eval(func(tag string) bool { return evalTag(ctxt, tag) })
+	}
+	if eval(expr) {
+		return nil
+	}
+	switch x := expr.(type) {
+	case *constraint.AndExpr:
+		return append(falseTerms(ctxt, x.X), falseTerms(ctxt, x.Y)...)
+	case *constraint.TagExpr:
+		return []string{x.Tag}
+	case *constraint.NotExpr:
+		if t, ok := x.X.(*constraint.TagExpr); ok {
+			return []string{"!" + t.Tag}
+		}
+	}
+	// OrExpr or a negated expression: every alternative is false
+	return []string{expr.String()}
+}
+
+func exprTags(expr constraint.Expr, tags map[string]bool) {
+	switch x := expr.(type) {
+	case *constraint.AndExpr:
+		exprTags(x.X, tags)
+		exprTags(x.Y, tags)
+	case *constraint.OrExpr:
+		exprTags(x.X, tags)
+		exprTags(x.Y, tags)
+	case *constraint.NotExpr:
+		exprTags(x.X, tags)
+	case *constraint.TagExpr:
+		tags[x.Tag] = true
+	}
+}
+
+var (
+	goPlatformsOnce sync.Once
+	goPlatforms     []buildutil.GoPlatform
+)
+
+// loadGoPlatforms returns the platforms supported by the go command, first
+// class ports first followed by 64-bit x86 and ARM ports.
+func loadGoPlatforms() []buildutil.GoPlatform {
+	goPlatformsOnce.Do(func() {
+		ps, err := buildutil.LoadGoPlatforms()
+		if err != nil {
+			logger.Named("build_constraints").Warn("loading platforms", zap.Error(err))
+			for _, goos := range []string{"linux", "darwin", "windows"} {
+				for _, goarch := range []string{"amd64", "arm64"} {
+					ps = append(ps, buildutil.GoPlatform{GOOS: goos, GOARCH: goarch, FirstClass: true})
+				}
+			}
+		}
+		rank := func(p buildutil.GoPlatform) int {
+			switch {
+			case p.FirstClass:
+				return 0
+			case p.GOARCH == "amd64" || p.GOARCH == "arm64":
+				return 1
+			}
+			return 2
+		}
+		sort.SliceStable(ps, func(i, j int) bool {
+			return rank(ps[i]) < rank(ps[j])
+		})
+		goPlatforms = ps
+	})
+	return goPlatforms
+}
+
+// maxConstraintExamples is the maximum number of example build configs.
+const maxConstraintExamples = 4
+
+// examples returns build configs that include a file with the filename
+// suffix of filename and constraint expr (which may be nil).
+func (r *BuildConstraintsRequest) examples(ctxt *build.Context, filename string, expr constraint.Expr) []BuildConfig {
+	// Tags of the constraint that are not a GOOS or GOARCH, these are
+	// tried in every combination (limited to 4 tags).
+	tags := make(map[string]bool)
+	if expr != nil {
+		exprTags(expr, tags)
+	}
+	var custom []string
+	for tag := range tags {
+		if !stringsContain(buildutil.KnownOSList(), tag) &&
+			!stringsContain(buildutil.KnownArchList(), tag) &&
+			tag != "unix" && !strings.HasPrefix(tag, "go1.") {
+			custom = append(custom, tag)
+		}
+	}
+	sort.Strings(custom)
+	if len(custom) > 4 {
+		custom = custom[:4]
+	}
+
+	platforms := append([]buildutil.GoPlatform{{GOOS: ctxt.GOOS, GOARCH: ctxt.GOARCH}},
+		loadGoPlatforms()...)
+
+	var configs []BuildConfig
+	seenOS := make(map[string]bool)
+	for mask := 0; mask < 1<<len(custom) && len(configs) < maxConstraintExamples; mask++ {
+		var extra []string
+		for i, tag := range custom {
+			if mask&(1<<i) != 0 {
+				extra = append(extra, tag)
+			}
+		}
+		for _, p := range platforms {
+			if seenOS[p.GOOS] {
+				continue // prefer examples for different OSes
+			}
+			c := BuildConfig{GOOS: p.GOOS, GOARCH: p.GOARCH, Tags: extra}
+			cc := c.context(ctxt)
+			cc.CgoEnabled = stringsContain(extra, "cgo")
+			if !buildutil.GoodOSArchFile(cc, filename, nil) {
+				continue
+			}
+			if expr != nil && !buildutil.NewConstraint(expr, nil).Eval(cc) {
+				continue
+			}
+			seenOS[p.GOOS] = true
+			configs = append(configs, c)
+			if len(configs) == maxConstraintExamples {
+				break
+			}
+		}
+	}
+	return configs
+}
+
+func (r *BuildConstraintsRequest) inspect() (*BuildConstraintsResponse, error) {
+	filename := filepath.Clean(r.Filename)
+	var src []byte
+	if r.Src != "" {
+		src = []byte(r.Src)
+	} else {
+		var err error
+		if src, err = os.ReadFile(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	base := contextFromEnv(r.Env, filename)
+	mgoTags := mgoBuildTags(base, filename)
+	ctxt := copyContext(base)
+	ctxt.BuildTags = append(ctxt.BuildTags, mgoTags...)
+
+	res := &BuildConstraintsResponse{
+		GOOS:         ctxt.GOOS,
+		GOARCH:       ctxt.GOARCH,
+		CgoEnabled:   ctxt.CgoEnabled,
+		Tags:         ctxt.BuildTags,
+		MgoBuildTags: mgoTags,
+	}
+	res.FileGOOS, res.FileGOARCH = fileOSArch(filename)
+
+	goodName := buildutil.GoodOSArchFile(ctxt, filename, nil)
+	if !goodName {
+		var want []string
+		if res.FileGOOS != "" {
+			want = append(want, "GOOS="+res.FileGOOS)
+		}
+		if res.FileGOARCH != "" {
+			want = append(want, "GOARCH="+res.FileGOARCH)
+		}
+		res.Excluded = append(res.Excluded, fmt.Sprintf("filename suffix requires %s",
+			strings.Join(want, " ")))
+	}
+
+	c, err := buildutil.ParseConstraint(ctxt, filename, src)
+	if err != nil {
+		return nil, err
+	}
+	expr := c.Expr()
+	goodExpr := true
+	if expr != nil {
+		res.Constraint = expr.String()
+		goodExpr = c.Eval(ctxt)
+		for _, term := range falseTerms(ctxt, expr) {
+			res.Excluded = append(res.Excluded, "constraint: "+term)
+		}
+	}
+
+	res.Included = goodName && goodExpr
+	// The examples toggle the tags of the constraint so start from a
+	// context without the tags of .mgo_build_tags.
+	res.Examples = r.examples(base, filename, expr)
+	return res, nil
+}
+
+func (r *BuildConstraintsRequest) Call() (interface{}, string) {
+	if r.Filename == "" {
+		return &BuildConstraintsResponse{}, "build_constraints: missing filename"
+	}
+	res, err := r.inspect()
+	if res == nil {
+		res = &BuildConstraintsResponse{}
+	}
+	return res, errStr(err)
+}
+
+func init() {
+	registry.Register("build_constraints", func(_ *Broker) Caller {
+		return &BuildConstraintsRequest{}
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileOSArch(t *testing.T) {
+	tests := []struct {
+		name, goos, goarch string
+	}{
+		{"a.go", "", ""},
+		{"linux.go", "", ""},
+		{"a_linux.go", "linux", ""},
+		{"a_amd64.go", "", "amd64"},
+		{"a_windows_arm64.go", "windows", "arm64"},
+		{"a_darwin_test.go", "darwin", ""},
+		{"a_foo_test.go", "", ""},
+	}
+	for _, x := range tests {
+		goos, goarch := fileOSArch(x.name)
+		if goos != x.goos || goarch != x.goarch {
+			t.Errorf("fileOSArch(%q) = %q, %q; want: %q, %q", x.name, goos, goarch, x.goos, x.goarch)
+		}
+	}
+}
+
+func TestBuildConstraints(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		".mgo_build_tags": "integration\n",
+		"a.go":            "package a\n",
+	})
+	env := map[string]string{"GOOS": "linux", "GOARCH": "amd64", "CGO_ENABLED": "0"}
+
+	inspect := func(name, src string) *BuildConstraintsResponse {
+		t.Helper()
+		r := &BuildConstraintsRequest{
+			Filename: filepath.Join(dir, name),
+			Src:      src,
+			Env:      env,
+		}
+		res, err := r.inspect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := inspect("a.go", "package a\n")
+	if !res.Included || len(res.Excluded) != 0 {
+		t.Errorf("a.go: expected file to be included: %+v", res)
+	}
+
+	// tags from .mgo_build_tags are used
+	res = inspect("b.go", "//go:build integration\n\npackage a\n")
+	if !res.Included || !reflect.DeepEqual(res.MgoBuildTags, []string{"integration"}) {
+		t.Errorf("b.go: expected file to be included: %+v", res)
+	}
+
+	res = inspect("c_windows.go", "//go:build cgo && !integration\n\npackage a\n")
+	if res.Included {
+		t.Errorf("c_windows.go: expected file to be excluded: %+v", res)
+	}
+	if res.FileGOOS != "windows" || res.Constraint != "cgo && !integration" {
+		t.Errorf("c_windows.go: unexpected constraint: %+v", res)
+	}
+	want := []string{
+		"filename suffix requires GOOS=windows",
+		"constraint: cgo",
+		"constraint: !integration",
+	}
+	if !reflect.DeepEqual(res.Excluded, want) {
+		t.Errorf("c_windows.go: Excluded = %q; want: %q", res.Excluded, want)
+	}
+	if len(res.Examples) != 1 {
+		t.Fatalf("c_windows.go: expected 1 example got: %+v", res.Examples)
+	}
+	if ex := res.Examples[0]; ex.GOOS != "windows" || !reflect.DeepEqual(ex.Tags, []string{"cgo"}) {
+		t.Errorf("c_windows.go: unexpected example: %+v", ex)
+	}
+
+	res = inspect("d.go", "//go:build darwin || freebsd\n\npackage a\n")
+	if res.Included {
+		t.Errorf("d.go: expected file to be excluded: %+v", res)
+	}
+	if want := []string{"constraint: darwin || freebsd"}; !reflect.DeepEqual(res.Excluded, want) {
+		t.Errorf("d.go: Excluded = %q; want: %q", res.Excluded, want)
+	}
+	// ios also satisfies "darwin"
+	var got []string
+	for _, ex := range res.Examples {
+		got = append(got, ex.String())
+	}
+	if want := []string{"darwin/amd64", "freebsd/amd64", "ios/amd64"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("d.go: Examples = %q; want: %q", got, want)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"go/build"
@@ -13,6 +15,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,36 +31,194 @@ import (
 
 type CompLintRequest struct {
 	Filename string `json:"filename"`
+
+	// Src is the unsaved source of Filename. If empty Filename is read
+	// from disk.
+	Src string `json:"src,omitempty"`
+
+	// Overlay maps the names of other unsaved files to their source.
+	Overlay map[string]string `json:"overlay,omitempty"`
+
+	// Vet runs go vet if the package compiles.
+	Vet bool `json:"vet,omitempty"`
+
+	// TypeCheck type checks the package in-process instead of building it.
+	// The package is still built if it uses cgo or is a main package
+	// without type errors, since only the build reports cgo and link
+	// errors.
+	TypeCheck bool `json:"type_check,omitempty"`
+
+	// Matrix is a list of build configs to also type check the package
+	// with, errors are labeled with the config that produced them.
+	Matrix []BuildConfig `json:"matrix,omitempty"`
 }
 
-type CompileError struct {
+// Compile error categories.
+const (
+	CategoryCompile = "compile"
+	CategoryVet     = "vet"
+	CategoryCgo     = "cgo"
+	CategoryLink    = "link"
+)
+
+// RelatedInformation is a secondary position of a CompileError, such as
+// the previous declaration of a redeclared identifier.
+type RelatedInformation struct {
 	Row     int    `json:"row"`
 	Col     int    `json:"col"`
 	File    string `json:"file"`
 	Message string `json:"message"`
 }
 
+type CompileError struct {
+	Row      int                  `json:"row"`
+	Col      int                  `json:"col"`
+	EndRow   int                  `json:"end_row,omitempty"`
+	EndCol   int                  `json:"end_col,omitempty"`
+	File     string               `json:"file"`
+	Message  string               `json:"message"`
+	Category string               `json:"category,omitempty"`
+	Analyzer string               `json:"analyzer,omitempty"`
+	Config   string               `json:"config,omitempty"` // see BuildConfig.String
+	Related  []RelatedInformation `json:"related,omitempty"`
+	Fixes    []SuggestedFix       `json:"fixes,omitempty"`
+}
+
 type CompLintReport struct {
 	Filename      string         `json:"filename"`
 	TopLevelError string         `json:"top_level_error,omitempty"`
 	CmdError      string         `json:"cmd_error,omitempty"`
 	Errors        []CompileError `json:"errors,omitempty"`
+
+	// Superseded is set if the build was canceled by a newer request for
+	// the same package.
+	Superseded bool `json:"superseded,omitempty"`
 }
 
 func (r *CompLintReport) NoError() bool {
 	return r.TopLevelError == "" && r.CmdError == "" && len(r.Errors) == 0
 }
 
-var compRe = regexp.MustCompile(`(?m)^([a-zA-Z]?:?[^:]+):(\d+):?(\d+)?:? (.+)$`)
+// compRe matches a "file:line[:col]: message" line, file may start with a
+// Windows drive letter.
+var compRe = regexp.MustCompile(`^((?:[a-zA-Z]:)?[^:\s][^:]*):(\d+)(?::(\d+))?:? (.+)$`)
+
+// gccRe matches the severity prefix of gcc and clang messages.
+var gccRe = regexp.MustCompile(`^(?:fatal )?(error|warning|note): `)
+
+func isCFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".c", ".h", ".cc", ".cpp", ".cxx", ".hh", ".hpp", ".hxx", ".m", ".f", ".F", ".for", ".f90":
+		return true
+	}
+	return false
+}
 
+// parseBuildOutput parses the output of building a single package (the lines
+// following a "# pkg" header) into CompileErrors.
+//
+// Indented lines that follow an error are either related information, if
+// they have a position, or a continuation of its message. Lines without a
+// position are link errors if there are no other errors since the linker
+// only runs once the package compiles.
+func parseBuildOutput(dirname string, lines []string) []CompileError {
+	abs := func(file string) string {
+		// also check for a drive letter since the output may be from
+		// a Windows go command
+		isDrive := len(file) > 2 && file[1] == ':' && (file[2] == '\\' || file[2] == '/')
+		if !filepath.IsAbs(file) && !isDrive && dirname != "" {
+			file = filepath.Join(dirname, file)
+		}
+		return file
+	}
+	var errs []CompileError
+	var other []string
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		indented := line[0] == '\t' || line[0] == ' '
+		line = strings.TrimPrefix(strings.TrimSpace(line), "vet: ")
+		m := compRe.FindStringSubmatch(line)
+		if indented && len(errs) != 0 {
+			e := &errs[len(errs)-1]
+			switch {
+			case m != nil:
+				row, _ := strconv.Atoi(m[2])
+				col, _ := strconv.Atoi(m[3])
+				e.Related = append(e.Related, RelatedInformation{
+					Row:     row,
+					Col:     col,
+					File:    abs(m[1]),
+					Message: m[4],
+				})
+			case e.Category != CategoryCgo:
+				// gcc and clang print the source and a caret
+				// marker which we ignore.
+				e.Message += " " + line
+			}
+			continue
+		}
+		if m == nil {
+			if !indented {
+				other = append(other, line)
+			}
+			continue
+		}
+		row, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		e := CompileError{
+			Row:      row,
+			Col:      col,
+			File:     abs(m[1]),
+			Message:  m[4],
+			Category: CategoryCompile,
+		}
+		if g := gccRe.FindStringSubmatch(e.Message); g != nil || isCFile(e.File) {
+			e.Category = CategoryCgo
+			if g != nil && g[1] == "note" && len(errs) != 0 {
+				prev := &errs[len(errs)-1]
+				prev.Related = append(prev.Related, RelatedInformation{
+					Row:     e.Row,
+					Col:     e.Col,
+					File:    e.File,
+					Message: strings.TrimPrefix(e.Message, g[0]),
+				})
+				continue
+			}
+		}
+		errs = append(errs, e)
+	}
+	if len(errs) == 0 {
+		for _, line := range other {
+			errs = append(errs, CompileError{Message: line, Category: CategoryLink})
+		}
+	}
+	return errs
+}
+
+// splitBuildOutput splits output into the lines of each package, packages
+// start with a "# pkg" header. Lines before the first header are returned as
+// their own package.
+func splitBuildOutput(out []byte) [][]string {
+	var pkgs [][]string
+	var cur []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if strings.HasPrefix(line, "# ") && len(cur) != 0 {
+			pkgs = append(pkgs, cur)
+			cur = nil
+		}
+		cur = append(cur, line)
+	}
+	if len(cur) != 0 {
+		pkgs = append(pkgs, cur)
+	}
+	return pkgs
+}
+
+// ParseErrors parses the text output of go build, install or test.
 func (r *CompLintReport) ParseErrors(dirname string, out []byte) {
-	const (
-		FileIndex     = 1
-		RowIndex      = 2
-		ColIndex      = 3
-		MsgIndex      = 4
-		SubmatchCount = 5
-	)
 	out = bytes.TrimSpace(out)
 
 	first := out
@@ -68,31 +229,12 @@ func (r *CompLintReport) ParseErrors(dirname string, out []byte) {
 		r.TopLevelError = string(first)
 	}
 
-	lines := bytes.Split(out, []byte{'\n'})
-	for i := 0; i < len(lines); i++ {
-		line := string(lines[i])
-		m := compRe.FindStringSubmatch(line)
-		if m == nil {
-			continue
-		}
-		file := string(m[1])
-		if !filepath.IsAbs(file) && dirname != "" {
-			file = filepath.Join(dirname, file)
+	for _, lines := range splitBuildOutput(out) {
+		errs := parseBuildOutput(dirname, lines)
+		if len(errs) != 0 && errs[0].Category == CategoryLink && !strings.HasPrefix(lines[0], "# ") {
+			continue // not package output: reported as the TopLevelError
 		}
-		row, _ := strconv.Atoi(string(m[2]))
-		col, _ := strconv.Atoi(string(m[3]))
-
-		msg := string(m[4])
-		if i < len(lines)-1 && len(lines[i+1]) != 0 && lines[i+1][0] == '\t' {
-			msg = msg + " " + string(bytes.TrimSpace(lines[i+1]))
-			i++
-		}
-		r.Errors = append(r.Errors, CompileError{
-			Row:     row,
-			Col:     col,
-			File:    file,
-			Message: msg,
-		})
+		r.Errors = append(r.Errors, errs...)
 	}
 }
 
@@ -201,13 +343,15 @@ func hasExitCode(err error, code int) bool {
 	return false
 }
 
-func isIFlagError(out []byte, err error) bool {
+// isFlagError returns if the go command failed because it does not support
+// flag (e.g. "-i").
+func isFlagError(flag string, out []byte, err error) bool {
 	if !hasExitCode(err, 2) {
 		return false
 	}
 	for _, msg := range []string{
-		"flag provided but not defined: -i",
-		"unknown flag -i",
+		"flag provided but not defined: " + flag,
+		"unknown flag " + flag,
 	} {
 		if bytes.Contains(out, []byte(msg)) {
 			return true
@@ -235,8 +379,10 @@ func removeArg(remove string, args []string) []string {
 	return a
 }
 
-func (c *CompLintRequest) buildTags(ctxt *build.Context) []string {
-	dir, err := contextutil.ContainingDirectory(ctxt, c.Filename, "", ".mgo_build_tags")
+// mgoBuildTags returns the build tags listed in the nearest ".mgo_build_tags"
+// file to filename.
+func mgoBuildTags(ctxt *build.Context, filename string) []string {
+	dir, err := contextutil.ContainingDirectory(ctxt, filename, "", ".mgo_build_tags")
 	if err != nil {
 		return nil
 	}
@@ -250,17 +396,140 @@ func (c *CompLintRequest) buildTags(ctxt *build.Context) []string {
 	return strings.Fields(string(data))
 }
 
-func (c *CompLintRequest) Compile(src []byte) *CompLintReport {
-	pkgname, _ := buildutil.ReadPackageName(c.Filename, src)
+// fileBuildContext returns the build.Context that matches filename and src
+// (see buildutil.MatchContext) along with any tags listed in the nearest
+// ".mgo_build_tags" file.
+func fileBuildContext(filename string, src []byte) *build.Context {
+	return envBuildContext(nil, filename, src)
+}
 
-	ctxt, _ := buildutil.MatchContext(nil, c.Filename, src)
+// envBuildContext is like fileBuildContext but the parent context is created
+// from env (see contextFromEnv).
+func envBuildContext(env map[string]string, filename string, src []byte) *build.Context {
+	parent := contextFromEnv(env, filename)
+	ctxt, _ := buildutil.MatchContext(parent, filename, src)
 	if ctxt == nil {
-		ctxt = &build.Default
+		ctxt = parent
 	}
-	if tags := c.buildTags(ctxt); len(tags) != 0 {
-		logger.Named("comp_lint").Info("mgo_build_tags", zap.Strings("tags", tags))
+	if tags := mgoBuildTags(ctxt, filename); len(tags) != 0 {
+		logger.Named("comp_lint").Debug("mgo_build_tags", zap.Strings("tags", tags))
 		ctxt.BuildTags = append(ctxt.BuildTags, tags...)
 	}
+	return ctxt
+}
+
+// overlayFiles returns the unsaved files that differ from the contents on
+// disk. The source of c.Filename is src.
+func (c *CompLintRequest) overlayFiles(src []byte) map[string][]byte {
+	files := make(map[string][]byte, len(c.Overlay)+1)
+	add := func(name string, src []byte) {
+		if data, err := os.ReadFile(name); err == nil && bytes.Equal(data, src) {
+			return
+		}
+		files[name] = src
+	}
+	if c.Src != "" {
+		add(c.Filename, src)
+	}
+	for name, s := range c.Overlay {
+		name = filepath.Clean(name)
+		if name != c.Filename {
+			add(name, []byte(s))
+		}
+	}
+	return files
+}
+
+// writeBuildOverlay writes files and the JSON file passed to the -overlay
+// build flag to a temporary directory and returns the name of the JSON file
+// and a map of the replacement files to the files they replace. The caller
+// must remove the directory once the build completes.
+func writeBuildOverlay(files map[string][]byte) (name, tmpdir string, replaced map[string]string, err error) {
+	tmpdir, err = os.MkdirTemp("", "margo-overlay-*")
+	if err != nil {
+		return "", "", nil, err
+	}
+	overlay := struct {
+		Replace map[string]string
+	}{Replace: make(map[string]string, len(files))}
+	replaced = make(map[string]string, len(files))
+	i := 0
+	for filename, src := range files {
+		path := filepath.Join(tmpdir, strconv.Itoa(i)+"_"+filepath.Base(filename))
+		if err := os.WriteFile(path, src, 0644); err != nil {
+			os.RemoveAll(tmpdir)
+			return "", "", nil, err
+		}
+		overlay.Replace[filename] = path
+		replaced[path] = filename
+		i++
+	}
+	data, err := json.Marshal(overlay)
+	if err == nil {
+		name = filepath.Join(tmpdir, "overlay.json")
+		err = os.WriteFile(name, data, 0644)
+	}
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return "", "", nil, err
+	}
+	return name, tmpdir, replaced, nil
+}
+
+// goCommand returns a go command that runs in the directory of c.Filename and
+// whose process group is killed if ctx is canceled.
+func (c *CompLintRequest) goCommand(ctx context.Context, ctxt *build.Context, args ...string) *exec.Cmd {
+	cmd := buildutil.GoCommandContext(ctx, ctxt, "go", args...)
+	cmd.Dir = filepath.Dir(c.Filename)
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// writeOverlay writes the unsaved files of the request to a temporary
+// directory and returns the -overlay flag, a map of the replacement files to
+// the files they replace and a func that removes the temporary directory.
+// The flag is empty if there are no unsaved files.
+func (c *CompLintRequest) writeOverlay(src []byte) (flag string, replaced map[string]string, cleanup func(), err error) {
+	files := c.overlayFiles(src)
+	if len(files) == 0 {
+		return "", nil, func() {}, nil
+	}
+	name, tmpdir, replaced, err := writeBuildOverlay(files)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return "-overlay=" + name, replaced, func() { os.RemoveAll(tmpdir) }, nil
+}
+
+// Compile builds the package containing c.Filename, or type checks it if
+// c.TypeCheck is set. If ctx is canceled by a newer build of the package the
+// returned report is marked Superseded.
+func (c *CompLintRequest) Compile(ctx context.Context, src []byte) *CompLintReport {
+	ctxt := fileBuildContext(c.Filename, src)
+
+	var r *CompLintReport
+	if c.TypeCheck {
+		r = c.checkTypes(ctx, ctxt, src)
+	}
+	if r == nil {
+		r = c.build(ctx, ctxt, src)
+	}
+	if r.Superseded {
+		return r
+	}
+	if r.NoError() && c.Vet {
+		c.vet(ctx, r, ctxt, src)
+	}
+	if len(c.Matrix) != 0 && !r.Superseded {
+		c.checkMatrix(ctx, r, src)
+		r.Superseded = isCompLintSuperseded(ctx)
+	}
+	return r
+}
+
+// build runs go build, install or test on the package.
+func (c *CompLintRequest) build(ctx context.Context, ctxt *build.Context, src []byte) *CompLintReport {
+	pkgname, _ := buildutil.ReadPackageName(c.Filename, src)
 
 	var args []string
 	switch {
@@ -280,40 +549,132 @@ func (c *CompLintRequest) Compile(src []byte) *CompLintReport {
 	if !goCmdIFlagSupported {
 		args = removeArg("-i", args)
 	}
+	overlay, _, cleanup, err := c.writeOverlay(src)
+	if err != nil {
+		return &CompLintReport{Filename: c.Filename, CmdError: err.Error()}
+	}
+	defer cleanup()
+	if overlay != "" {
+		args = insertArgs(args, overlay)
+	}
+	jsonOutput := goCmdJSONSupported
+	if jsonOutput {
+		args = insertArgs(args, "-json")
+	}
 
-	dir := filepath.Dir(c.Filename)
-	cmd := buildutil.GoCommand(ctxt, "go", args...)
-	cmd.Dir = dir
-
+	cmd := c.goCommand(ctx, ctxt, args...)
 	out, err := cmd.CombinedOutput()
-	if err != nil && isIFlagError(out, err) && containsArg("-i", args) {
-		args = removeArg("-i", args)
-		cmd = buildutil.GoCommand(ctxt, "go", args...)
-		cmd.Dir = dir
-		out, err = cmd.CombinedOutput()
+	for _, flag := range []string{"-i", "-json"} {
+		if err != nil && isFlagError(flag, out, err) && containsArg(flag, args) {
+			args = removeArg(flag, args)
+			jsonOutput = jsonOutput && flag != "-json"
+			cmd = c.goCommand(ctx, ctxt, args...)
+			out, err = cmd.CombinedOutput()
+		}
 	}
 	r := &CompLintReport{
 		Filename: c.Filename,
 	}
+	if isCompLintSuperseded(ctx) {
+		r.Superseded = true
+		return r
+	}
 	if err != nil {
 		r.CmdError = err.Error()
-		r.ParseErrors(cmd.Dir, out)
+		if jsonOutput {
+			r.ParseJSONErrors(cmd.Dir, out)
+		} else {
+			r.ParseErrors(cmd.Dir, out)
+		}
+		addImportFixes(c.Filename, src, r.Errors)
 	}
 	return r
 }
 
+// vet runs go vet, with the optional vet flags, on the package and adds its
+// diagnostics to r.
+func (c *CompLintRequest) vet(ctx context.Context, r *CompLintReport, ctxt *build.Context, src []byte, flags ...string) {
+	overlay, replaced, cleanup, err := c.writeOverlay(src)
+	if err != nil {
+		r.CmdError = err.Error()
+		return
+	}
+	defer cleanup()
+	args := []string{"vet", "-json"}
+	if overlay != "" {
+		args = append(args, overlay)
+	}
+	args = append(args, flags...)
+	cmd := c.goCommand(ctx, ctxt, args...)
+	out, err := cmd.CombinedOutput()
+	if isCompLintSuperseded(ctx) {
+		r.Superseded = true
+		return
+	}
+	if perr := r.ParseVetErrors(c.Filename, src, replaced, out); perr != nil {
+		logger.Named("comp_lint").Warn("error parsing vet output", zap.Error(perr))
+	}
+	if err != nil && len(r.Errors) == 0 {
+		r.CmdError = err.Error()
+		r.ParseErrors(cmd.Dir, out)
+	}
+}
+
+// insertArgs inserts extra after the go sub-command args[0].
+func insertArgs(args []string, extra ...string) []string {
+	return append(args[:1:1], append(extra, args[1:]...)...)
+}
+
 var compLintGroup singleflight.Group
 var compLintCache = lru.New(128)
 
+// cacheKey returns the compLintCache key for the request. If there are no
+// other unsaved files it matches the key used by fmt.
+func (c *CompLintRequest) cacheKey(src []byte) string {
+	key := fileCacheKey(c.Filename, string(src))
+	if len(c.Overlay) == 0 {
+		return key
+	}
+	names := make([]string, 0, len(c.Overlay))
+	for name := range c.Overlay {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range names {
+		b.WriteString("|" + name + "|" + fileCacheKey(name, c.Overlay[name]))
+	}
+	return b.String()
+}
+
 func (c *CompLintRequest) Call() (interface{}, string) {
-	src, err := ioutil.ReadFile(c.Filename)
-	if err != nil {
-		return &CompLintReport{CmdError: err.Error()}, err.Error()
+	c.Filename = filepath.Clean(c.Filename)
+	src := []byte(c.Src)
+	if c.Src == "" {
+		var err error
+		if src, err = ioutil.ReadFile(c.Filename); err != nil {
+			return &CompLintReport{CmdError: err.Error()}, err.Error()
+		}
 	}
-	key := fileCacheKey(c.Filename, string(src))
-	v, _, _ := compLintGroup.Do(key, func() (interface{}, error) {
-		r := c.Compile(src)
-		compLintCache.Add(key, r.NoError())
+	key := c.cacheKey(src)
+	groupKey := key
+	if c.Vet {
+		groupKey += "|vet"
+	}
+	if c.TypeCheck {
+		groupKey += "|type_check"
+	}
+	if len(c.Matrix) != 0 {
+		groupKey += "|" + matrixKey(c.Matrix)
+	}
+	v, _, _ := compLintGroup.Do(groupKey, func() (interface{}, error) {
+		ctx, done := startCompLintBuild(filepath.Dir(c.Filename))
+		defer done()
+		r := c.Compile(ctx, src)
+		if !r.Superseded {
+			compLintCache.Add(key, r.NoError())
+		}
 		return r, nil
 	})
 	r, ok := v.(*CompLintReport)
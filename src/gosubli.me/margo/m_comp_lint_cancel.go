@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errCompLintSuperseded is the cause of a comp_lint build being canceled by
+// a newer build of the same package.
+var errCompLintSuperseded = errors.New("comp_lint: build superseded by a newer request")
+
+type compLintBuild struct {
+	cancel context.CancelCauseFunc
+}
+
+// compLintBuilds tracks the in-flight comp_lint build of each package
+// directory.
+var compLintBuilds = struct {
+	sync.Mutex
+	m map[string]*compLintBuild
+}{m: make(map[string]*compLintBuild)}
+
+// startCompLintBuild registers a build of the package in dir, canceling any
+// in-flight build of the same package. The returned func must be called once
+// the build completes.
+func startCompLintBuild(dir string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	b := &compLintBuild{cancel: cancel}
+
+	compLintBuilds.Lock()
+	if prev := compLintBuilds.m[dir]; prev != nil {
+		prev.cancel(errCompLintSuperseded)
+	}
+	compLintBuilds.m[dir] = b
+	compLintBuilds.Unlock()
+
+	return ctx, func() {
+		compLintBuilds.Lock()
+		if compLintBuilds.m[dir] == b {
+			delete(compLintBuilds.m, dir)
+		}
+		compLintBuilds.Unlock()
+		cancel(nil)
+	}
+}
+
+func isCompLintSuperseded(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCompLintSuperseded)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// buildEvent is an event written by "go build -json" (see "go help buildjson").
+type buildEvent struct {
+	ImportPath string
+	Action     string
+	Output     string
+}
+
+// ParseJSONErrors parses the output of go build, install or test run with
+// the -json flag. Lines that are not JSON (the go command may print early
+// errors as text) are parsed with ParseErrors.
+func (r *CompLintReport) ParseJSONErrors(dirname string, out []byte) {
+	var text bytes.Buffer
+	var order []string
+	pkgs := make(map[string]*strings.Builder)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), len(out)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var e buildEvent
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &e) != nil || e.Action == "" {
+			text.Write(line)
+			text.WriteByte('\n')
+			continue
+		}
+		if e.Action != "build-output" {
+			continue
+		}
+		b := pkgs[e.ImportPath]
+		if b == nil {
+			b = new(strings.Builder)
+			pkgs[e.ImportPath] = b
+			order = append(order, e.ImportPath)
+		}
+		b.WriteString(e.Output)
+	}
+
+	if text.Len() != 0 {
+		r.ParseErrors(dirname, text.Bytes())
+	}
+	for _, path := range order {
+		lines := strings.Split(pkgs[path].String(), "\n")
+		r.Errors = append(r.Errors, parseBuildOutput(dirname, lines)...)
+	}
+}
+
+// vetDiagnostic is a diagnostic written by "go vet -json".
+type vetDiagnostic struct {
+	Category       string `json:"category,omitempty"`
+	Posn           string `json:"posn"`
+	End            string `json:"end"`
+	Message        string `json:"message"`
+	SuggestedFixes []struct {
+		Message string `json:"message"`
+		Edits   []struct {
+			Filename string `json:"filename"`
+			Start    int    `json:"start"`
+			End      int    `json:"end"`
+			New      string `json:"new"`
+		} `json:"edits"`
+	} `json:"suggested_fixes,omitempty"`
+	Related []struct {
+		Posn    string `json:"posn"`
+		End     string `json:"end"`
+		Message string `json:"message"`
+	} `json:"related,omitempty"`
+}
+
+var vetPosnRe = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?$`)
+
+func parseVetPosn(posn string, overlay map[string]string) (file string, row, col int) {
+	m := vetPosnRe.FindStringSubmatch(posn)
+	if m == nil {
+		return posn, 0, 0
+	}
+	file = m[1]
+	if s, ok := overlay[file]; ok {
+		file = s
+	}
+	row, _ = strconv.Atoi(m[2])
+	col, _ = strconv.Atoi(m[3])
+	return file, row, col
+}
+
+// ParseVetErrors parses the output of "go vet -json" which is a sequence of
+// indented JSON objects, one per package, mapping package => analyzer =>
+// diagnostics (or an error) and may be interleaved with "# pkg" comments.
+// Suggested fixes are only included for filename, whose contents are src.
+// Overlay maps the names of -overlay replacement files to the files they
+// replace.
+func (r *CompLintReport) ParseVetErrors(filename string, src []byte, overlay map[string]string, out []byte) error {
+	var objects [][]byte
+	start := -1
+	for off := 0; off < len(out); {
+		line := out[off:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		switch string(bytes.TrimRight(line, "\r\n")) {
+		case "{":
+			start = off
+		case "}":
+			if start >= 0 {
+				objects = append(objects, out[start:off+len(line)])
+				start = -1
+			}
+		}
+		off += len(line)
+	}
+	for _, data := range objects {
+		var tree map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return err
+		}
+		pkgs := make([]string, 0, len(tree))
+		for pkg := range tree {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			analyzers := make([]string, 0, len(tree[pkg]))
+			for name := range tree[pkg] {
+				analyzers = append(analyzers, name)
+			}
+			sort.Strings(analyzers)
+			for _, name := range analyzers {
+				var diags []vetDiagnostic
+				if json.Unmarshal(tree[pkg][name], &diags) != nil {
+					continue // {"error": "..."}
+				}
+				for _, d := range diags {
+					r.Errors = append(r.Errors, vetCompileError(name, d, filename, src, overlay))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func vetCompileError(analyzer string, d vetDiagnostic, filename string, src []byte, overlay map[string]string) CompileError {
+	e := CompileError{
+		Message:  d.Message,
+		Category: CategoryVet,
+		Analyzer: analyzer,
+	}
+	e.File, e.Row, e.Col = parseVetPosn(d.Posn, overlay)
+	if d.End != "" {
+		if file, row, col := parseVetPosn(d.End, overlay); file == e.File {
+			e.EndRow, e.EndCol = row, col
+		}
+	}
+	for _, rel := range d.Related {
+		file, row, col := parseVetPosn(rel.Posn, overlay)
+		e.Related = append(e.Related, RelatedInformation{
+			Row:     row,
+			Col:     col,
+			File:    file,
+			Message: rel.Message,
+		})
+	}
+Fixes:
+	for _, fix := range d.SuggestedFixes {
+		var edits []TextEdit
+		for _, te := range fix.Edits {
+			name := filepath.Clean(te.Filename)
+			if s, ok := overlay[name]; ok {
+				name = s
+			}
+			if name != filename || te.Start > te.End || te.End > len(src) {
+				continue Fixes
+			}
+			edits = append(edits, newTextEdit(src, te.Start, te.End, te.New))
+		}
+		if len(edits) != 0 {
+			e.Fixes = append(e.Fixes, SuggestedFix{Message: fix.Message, Edits: edits, Hash: sourceHash(src)})
+		}
+	}
+	return e
+}
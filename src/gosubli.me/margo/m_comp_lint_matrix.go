@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charlievieth/buildutil"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/tools/go/packages"
+)
+
+// BuildConfig is a GOOS/GOARCH and build tag combination that comp_lint
+// type checks a package with.
+type BuildConfig struct {
+	GOOS   string   `json:"goos"`
+	GOARCH string   `json:"goarch"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// String returns the label of the config, e.g. "windows/amd64 tags=foo,bar".
+func (b BuildConfig) String() string {
+	s := b.GOOS + "/" + b.GOARCH
+	if len(b.Tags) != 0 {
+		s += " tags=" + strings.Join(b.Tags, ",")
+	}
+	return s
+}
+
+// context returns a copy of orig for the config. Cgo is disabled since
+// cross-compiling cgo requires a C toolchain for the target.
+func (b BuildConfig) context(orig *build.Context) *build.Context {
+	ctxt := copyContext(orig)
+	ctxt.GOOS = b.GOOS
+	ctxt.GOARCH = b.GOARCH
+	ctxt.CgoEnabled = false
+	ctxt.BuildTags = append(ctxt.BuildTags, b.Tags...)
+	return ctxt
+}
+
+func matrixKey(matrix []BuildConfig) string {
+	a := make([]string, len(matrix))
+	for i, b := range matrix {
+		a[i] = b.String()
+	}
+	return strings.Join(a, "|")
+}
+
+const matrixLoadMode = packages.NeedName | packages.NeedFiles |
+	packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedTypes |
+	packages.NeedDeps
+
+// typeCheckConfig type checks the package containing c.Filename for config
+// and returns the type errors labeled with the config.
+func (c *CompLintRequest) typeCheckConfig(ctx context.Context, config BuildConfig, files map[string][]byte) ([]CompileError, error) {
+	ctxt := contextFromEnv(nil, c.Filename)
+	ctxt.BuildTags = append(ctxt.BuildTags, mgoBuildTags(ctxt, c.Filename)...)
+	ctxt = config.context(ctxt)
+
+	isTest := strings.HasSuffix(c.Filename, "_test.go")
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    matrixLoadMode,
+		Dir:     filepath.Dir(c.Filename),
+		Env:     buildutil.GoCommand(ctxt, "go").Env,
+		Tests:   isTest,
+		Overlay: files,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, err
+	}
+	var errs []CompileError
+	for _, pkg := range pkgs {
+		// Only check the test variant of test files since it
+		// includes the package files.
+		isVariant := strings.HasSuffix(pkg.ID, ".test]")
+		if strings.HasSuffix(pkg.ID, ".test") || isVariant != isTest {
+			continue
+		}
+		for _, e := range pkg.Errors {
+			file, row, col := parseVetPosn(e.Pos, nil)
+			if row == 0 {
+				file = ""
+			}
+			errs = append(errs, CompileError{
+				Row:      row,
+				Col:      col,
+				File:     file,
+				Message:  e.Msg,
+				Category: CategoryCompile,
+				Config:   config.String(),
+			})
+		}
+	}
+	return errs, nil
+}
+
+// checkMatrix type checks the package for each config of c.Matrix and adds
+// the errors to r.
+func (c *CompLintRequest) checkMatrix(ctx context.Context, r *CompLintReport, src []byte) {
+	files := c.overlayFiles(src)
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(runtime.NumCPU())
+	for _, config := range c.Matrix {
+		config := config
+		g.Go(func() error {
+			errs, err := c.typeCheckConfig(ctx, config, files)
+			if err != nil {
+				logger.Named("comp_lint").Warn("matrix type check",
+					zap.Stringer("config", config), zap.Error(err))
+				errs = []CompileError{{
+					Message:  err.Error(),
+					Category: CategoryCompile,
+					Config:   config.String(),
+				}}
+			}
+			mu.Lock()
+			r.Errors = append(r.Errors, errs...)
+			mu.Unlock()
+			return nil
+		})
+	}
+	g.Wait()
+
+	// sort the errors of each config by position, keeping the order of
+	// c.Matrix
+	order := make(map[string]int, len(c.Matrix))
+	for i, config := range c.Matrix {
+		order[config.String()] = i + 1
+	}
+	sort.SliceStable(r.Errors, func(i, j int) bool {
+		e1, e2 := &r.Errors[i], &r.Errors[j]
+		if o1, o2 := order[e1.Config], order[e2.Config]; o1 != o2 {
+			return o1 < o2
+		}
+		if e1.Config == "" {
+			return false // keep build errors in order
+		}
+		if e1.File != e2.File {
+			return e1.File < e2.File
+		}
+		if e1.Row != e2.Row {
+			return e1.Row < e2.Row
+		}
+		return e1.Col < e2.Col
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCompLintOverlay(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a.go": "package lint\n\nfunc A() int { return B() }\n",
+		"b.go": "package lint\n\nfunc B() int { return 1 }\n",
+	})
+	filename := filepath.Join(dir, "a.go")
+
+	// the files on disk compile
+	c := &CompLintRequest{Filename: filename}
+	v, _ := c.Call()
+	if r := v.(*CompLintReport); !r.NoError() {
+		t.Fatalf("unexpected errors: %+v", r)
+	}
+
+	// unsaved buffers are compiled instead of the files on disk
+	c = &CompLintRequest{
+		Filename: filename,
+		Src:      "package lint\n\nfunc A() int { return B() }\n",
+		Overlay: map[string]string{
+			filepath.Join(dir, "b.go"): "package lint\n\nfunc B() string { return \"\" }\n",
+		},
+	}
+	v, _ = c.Call()
+	r := v.(*CompLintReport)
+	if len(r.Errors) != 1 {
+		t.Fatalf("expected 1 error got: %+v", r)
+	}
+	if e := r.Errors[0]; e.File != filename || e.Row != 3 || e.Category != CategoryCompile {
+		t.Errorf("unexpected error: %+v", e)
+	}
+
+	// vet is run once the package compiles
+	c = &CompLintRequest{
+		Filename: filename,
+		Src:      "package lint\n\nimport \"fmt\"\n\nfunc A() int { fmt.Printf(\"%d\", \"a\"); return B() }\n",
+		Vet:      true,
+	}
+	v, _ = c.Call()
+	r = v.(*CompLintReport)
+	if len(r.Errors) != 1 {
+		t.Fatalf("expected 1 error got: %+v", r)
+	}
+	if e := r.Errors[0]; e.File != filename || e.Row != 5 || e.Analyzer != "printf" {
+		t.Errorf("unexpected error: %+v", e)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const out = "# x\n" +
+		"./a.go:4:6: x redeclared in this block\n" +
+		"\t./a.go:3:6: other declaration of x\n" +
+		`C:\src\x\b.go:5:23: cannot use "" (untyped string constant)` + "\n" +
+		"\tas int value in return statement\n" +
+		"# y\n" +
+		"./c.go: In function 'f':\n" +
+		"./c.go:4:22: error: 'bogus' undeclared\n" +
+		"    4 | int f(void) { return bogus; }\n" +
+		"./c.go:4:22: note: each undeclared identifier is reported only once\n" +
+		"# z\n" +
+		"main.main: relocation target runtime.xyz not defined\n"
+
+	var r CompLintReport
+	r.ParseErrors("/src/x", []byte(out))
+	if r.TopLevelError != "" {
+		t.Errorf("TopLevelError: got: %q want: %q", r.TopLevelError, "")
+	}
+	want := []CompileError{
+		{
+			Row: 4, Col: 6, File: "/src/x/a.go", Message: "x redeclared in this block",
+			Category: CategoryCompile,
+			Related: []RelatedInformation{
+				{Row: 3, Col: 6, File: "/src/x/a.go", Message: "other declaration of x"},
+			},
+		},
+		{
+			Row: 5, Col: 23, File: `C:\src\x\b.go`,
+			Message:  `cannot use "" (untyped string constant) as int value in return statement`,
+			Category: CategoryCompile,
+		},
+		{
+			Row: 4, Col: 22, File: "/src/x/c.go", Message: "error: 'bogus' undeclared",
+			Category: CategoryCgo,
+			Related: []RelatedInformation{
+				{Row: 4, Col: 22, File: "/src/x/c.go", Message: "each undeclared identifier is reported only once"},
+			},
+		},
+		{
+			Message:  "main.main: relocation target runtime.xyz not defined",
+			Category: CategoryLink,
+		},
+	}
+	if !reflect.DeepEqual(r.Errors, want) {
+		t.Errorf("ParseErrors:\ngot:  %+v\nwant: %+v", r.Errors, want)
+	}
+}
+
+func TestParseJSONErrors(t *testing.T) {
+	const out = "go: warning: ignoring go.mod in $GOPATH\n" +
+		`{"ImportPath":"x","Action":"build-output","Output":"# x\n"}` + "\n" +
+		`{"ImportPath":"x","Action":"build-output","Output":"./a.go:4:6: x redeclared in this block\n"}` + "\n" +
+		`{"ImportPath":"x","Action":"build-output","Output":"\t./a.go:3:6: other declaration of x\n"}` + "\n" +
+		`{"ImportPath":"x","Action":"build-fail"}` + "\n"
+
+	var r CompLintReport
+	r.ParseJSONErrors("/src/x", []byte(out))
+	if r.TopLevelError != "go: warning: ignoring go.mod in $GOPATH" {
+		t.Errorf("TopLevelError: got: %q", r.TopLevelError)
+	}
+	if len(r.Errors) != 1 {
+		t.Fatalf("expected 1 error got: %+v", r.Errors)
+	}
+	if e := r.Errors[0]; e.File != "/src/x/a.go" || e.Row != 4 || len(e.Related) != 1 {
+		t.Errorf("unexpected error: %+v", e)
+	}
+}
+
+func TestParseVetErrors(t *testing.T) {
+	const src = "package x\n\nimport \"fmt\"\n\nfunc F() { fmt.Sprintf(\"%s\", 1) }\n"
+	const out = "# x\n" + `{
+	"x": {
+		"printf": [
+			{
+				"posn": "/src/x/a.go:5:25",
+				"end": "/src/x/a.go:5:27",
+				"message": "fmt.Sprintf format %s has arg 1 of wrong type int",
+				"suggested_fixes": [
+					{
+						"message": "Use %d",
+						"edits": [{"filename": "/src/x/a.go", "start": 35, "end": 37, "new": "%d"}]
+					}
+				]
+			}
+		],
+		"unusedresult": [
+			{
+				"posn": "/src/x/a.go:5:12",
+				"end": "/src/x/a.go:5:23",
+				"message": "result of fmt.Sprintf call not used"
+			}
+		]
+	}
+}
+`
+	var r CompLintReport
+	if err := r.ParseVetErrors("/src/x/a.go", []byte(src), nil, []byte(out)); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Errors) != 2 {
+		t.Fatalf("expected 2 errors got: %+v", r.Errors)
+	}
+	e := r.Errors[0]
+	if e.Analyzer != "printf" || e.Category != CategoryVet || e.Row != 5 || e.Col != 25 ||
+		e.EndRow != 5 || e.EndCol != 27 {
+		t.Errorf("unexpected error: %+v", e)
+	}
+	if len(e.Fixes) != 1 || e.Fixes[0].Edits[0].NewText != "%d" {
+		t.Errorf("unexpected fixes: %+v", e.Fixes)
+	}
+}
+
+func TestCompLintSuperseded(t *testing.T) {
+	ctx1, done1 := startCompLintBuild("/src/x")
+	ctx2, done2 := startCompLintBuild("/src/x")
+	defer done2()
+	ctx3, done3 := startCompLintBuild("/src/y")
+	defer done3()
+
+	if !isCompLintSuperseded(ctx1) {
+		t.Error("expected first build to be superseded")
+	}
+	if ctx2.Err() != nil || ctx3.Err() != nil {
+		t.Error("newer builds should not be canceled")
+	}
+	// completing a superseded build must not remove the newer one
+	done1()
+	compLintBuilds.Lock()
+	n := len(compLintBuilds.m)
+	compLintBuilds.Unlock()
+	if n != 2 {
+		t.Errorf("in-flight builds: got: %d want: %d", n, 2)
+	}
+
+	// the report of a canceled build is marked superseded
+	dir := writeTestModule(t, map[string]string{"a.go": "package lint\n"})
+	c := &CompLintRequest{Filename: filepath.Join(dir, "a.go")}
+	ctx, done := startCompLintBuild(dir)
+	_, done4 := startCompLintBuild(dir)
+	defer done4()
+	defer done()
+	if r := c.Compile(ctx, []byte("package lint\n")); !r.Superseded || len(r.Errors) != 0 {
+		t.Errorf("expected superseded report got: %+v", r)
+	}
+}
+
+func TestCompLintMatrix(t *testing.T) {
+	// b is an int on the host GOOS, a string on other and undefined on third
+	var goos []string
+	for _, s := range []string{"linux", "windows", "darwin", "freebsd"} {
+		if s != runtime.GOOS {
+			goos = append(goos, s)
+		}
+	}
+	other, third := goos[0], goos[1]
+	hostArch := "arm64"
+	if runtime.GOARCH == hostArch {
+		hostArch = "amd64"
+	}
+	dir := writeTestModule(t, map[string]string{
+		"a.go":                      "package lint\n\nfunc A() int { return b() }\n",
+		"b_" + runtime.GOOS + ".go": "package lint\n\nfunc b() int { return 1 }\n",
+		"b_" + other + ".go":        "package lint\n\nfunc b() string { return \"\" }\n",
+	})
+	c := &CompLintRequest{
+		Filename: filepath.Join(dir, "a.go"),
+		Matrix: []BuildConfig{
+			{GOOS: other, GOARCH: "amd64"},
+			{GOOS: runtime.GOOS, GOARCH: hostArch},
+			{GOOS: third, GOARCH: "arm64"},
+		},
+	}
+	v, _ := c.Call()
+	r := v.(*CompLintReport)
+	var configs []string
+	for _, e := range r.Errors {
+		if e.File != c.Filename || e.Row != 3 {
+			t.Errorf("unexpected error: %+v", e)
+		}
+		configs = append(configs, e.Config)
+	}
+	want := []string{other + "/amd64", third + "/arm64"}
+	if !reflect.DeepEqual(configs, want) {
+		t.Errorf("configs: got: %q want: %q", configs, want)
+	}
+}
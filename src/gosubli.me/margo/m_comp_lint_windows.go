@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows where the default cmd.Cancel, which
+// kills the process, is used.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charlievieth/buildutil"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/packages"
+)
+
+// ModuleDiagnosticsRequest builds, vets and type checks every package in the
+// main module, or the modules of the go.work workspace, containing Dir.
+type ModuleDiagnosticsRequest struct {
+	Dir string            `json:"dir"`
+	Env map[string]string `json:"env"`
+
+	// Vet runs the lint analyzers on each package.
+	Vet bool `json:"vet"`
+
+	// Parallel is the maximum number of packages analyzed concurrently,
+	// defaults to the number of CPUs.
+	Parallel int `json:"parallel,omitempty"`
+
+	// Stream, if set, is the token that the diagnostics of each package are
+	// sent with as they complete.
+	Stream string `json:"stream,omitempty"`
+}
+
+// PackageDiagnostics are the diagnostics of a single package grouped by
+// file.
+type PackageDiagnostics struct {
+	ImportPath string                    `json:"import_path"`
+	Dir        string                    `json:"dir"`
+	Files      map[string][]CompileError `json:"files"`
+	// Errors are errors not associated with a file.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type ModuleDiagnosticsResponse struct {
+	Modules  []string                  `json:"modules"`
+	Packages int                       `json:"packages"`
+	Files    map[string][]CompileError `json:"files"`
+	Errors   []string                  `json:"errors,omitempty"`
+}
+
+// modules returns the directories of the main modules, there is more than one
+// in workspace mode.
+func (r *ModuleDiagnosticsRequest) modules(ctx context.Context, ctxt *build.Context) ([]string, error) {
+	cmd := buildutil.GoCommandContext(ctx, ctxt, "go", "list", "-m", "-f", "{{.Dir}}")
+	cmd.Dir = r.Dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, s := range strings.Fields(string(out)) {
+		dirs = append(dirs, filepath.Clean(s))
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("diagnostics_module: no main module found for directory: %s", r.Dir)
+	}
+	return dirs, nil
+}
+
+// build builds every package matching patterns and returns the compile
+// errors.
+func (r *ModuleDiagnosticsRequest) build(ctx context.Context, ctxt *build.Context, patterns []string) *CompLintReport {
+	args := []string{"build", "-o", os.DevNull}
+	if goCmdJSONSupported {
+		args = append(args, "-json")
+	}
+	cmd := buildutil.GoCommandContext(ctx, ctxt, "go", append(args, patterns...)...)
+	cmd.Dir = r.Dir
+	setProcessGroup(cmd)
+	out, err := cmd.CombinedOutput()
+	rep := &CompLintReport{}
+	if err != nil {
+		rep.CmdError = err.Error()
+		if goCmdJSONSupported {
+			rep.ParseJSONErrors(cmd.Dir, out)
+		} else {
+			rep.ParseErrors(cmd.Dir, out)
+		}
+	}
+	return rep
+}
+
+// diagnosticKey is used to remove type errors that are also reported by the
+// compiler.
+type diagnosticKey struct {
+	file     string
+	row, col int
+}
+
+// compileErrorSet is the set of build errors grouped by file.
+type compileErrorSet struct {
+	mu    sync.Mutex
+	files map[string][]CompileError
+}
+
+// take removes and returns the errors of filename.
+func (s *compileErrorSet) take(filename string) []CompileError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := s.files[filename]
+	delete(s.files, filename)
+	return errs
+}
+
+func (r *ModuleDiagnosticsRequest) analyze(pkg *packages.Package, buildErrs *compileErrorSet) *PackageDiagnostics {
+	pd := &PackageDiagnostics{
+		ImportPath: pkg.PkgPath,
+		Files:      make(map[string][]CompileError),
+	}
+	if len(pkg.GoFiles) != 0 {
+		pd.Dir = filepath.Dir(pkg.GoFiles[0])
+	}
+	seen := make(map[diagnosticKey]bool)
+	add := func(e CompileError) {
+		pd.Files[e.File] = append(pd.Files[e.File], e)
+	}
+
+	for _, name := range append(pkg.CompiledGoFiles, pkg.OtherFiles...) {
+		for _, e := range buildErrs.take(name) {
+			seen[diagnosticKey{e.File, e.Row, e.Col}] = true
+			add(e)
+		}
+	}
+
+	for _, e := range pkg.Errors {
+		file, row, col := parseVetPosn(e.Pos, nil)
+		if e.Pos == "" || e.Pos == "-" || row == 0 {
+			pd.Errors = append(pd.Errors, e.Msg)
+			continue
+		}
+		if seen[diagnosticKey{file, row, col}] {
+			continue
+		}
+		add(CompileError{
+			Row:      row,
+			Col:      col,
+			File:     file,
+			Message:  e.Msg,
+			Category: CategoryCompile,
+		})
+	}
+
+	if r.Vet && pkg.Types != nil && len(pkg.Syntax) != 0 {
+		conf := loadLintConfig(pd.Dir)
+		diags, err := newLintDriver(pkg).Run(conf.Analyzers(nil))
+		if err != nil {
+			logger.Named("diagnostics_module").Debug("analysis error",
+				zap.String("package", pkg.ID), zap.Error(err))
+		}
+		ignores := make(map[string]*lintIgnores, len(pkg.Syntax))
+		for _, af := range pkg.Syntax {
+			name := pkg.Fset.File(af.Pos()).Name()
+			if src, err := os.ReadFile(name); err == nil {
+				ignores[name] = newLintIgnores(pkg.Fset, af, src)
+			}
+		}
+		for _, d := range diags {
+			pos := pkg.Fset.Position(d.Pos)
+			if ignores[pos.Filename].Ignored(d.Analyzer.Name, d.Pos) {
+				continue
+			}
+			e := CompileError{
+				Row:      pos.Line,
+				Col:      pos.Column,
+				File:     pos.Filename,
+				Message:  d.Message,
+				Category: CategoryVet,
+				Analyzer: d.Analyzer.Name,
+			}
+			if end := pkg.Fset.Position(d.End); d.End.IsValid() && end.Filename == pos.Filename {
+				e.EndRow, e.EndCol = end.Line, end.Column
+			}
+			for _, rel := range d.Related {
+				p := pkg.Fset.Position(rel.Pos)
+				e.Related = append(e.Related, RelatedInformation{
+					Row:     p.Line,
+					Col:     p.Column,
+					File:    p.Filename,
+					Message: rel.Message,
+				})
+			}
+			add(e)
+		}
+	}
+	return pd
+}
+
+// modulePackages removes the generated test main packages and packages that
+// also have a test variant, which contains the same files, from pkgs.
+func modulePackages(pkgs []*packages.Package) []*packages.Package {
+	variants := make(map[string]bool)
+	for _, p := range pkgs {
+		if p.ID == p.PkgPath+" ["+p.PkgPath+".test]" {
+			variants[p.PkgPath] = true
+		}
+	}
+	a := pkgs[:0]
+	for _, p := range pkgs {
+		if strings.HasSuffix(p.ID, ".test") || (p.ID == p.PkgPath && variants[p.PkgPath]) {
+			continue
+		}
+		a = append(a, p)
+	}
+	return a
+}
+
+// exportDataReadableCache caches the result of exportDataReadable by GOROOT.
+var exportDataReadableCache sync.Map
+
+// exportDataReadable reports if the export data written by the go command of
+// ctxt can be read by gcexportdata.
+func exportDataReadable(ctx context.Context, ctxt *build.Context) bool {
+	if v, ok := exportDataReadableCache.Load(ctxt.GOROOT); ok {
+		return v.(bool)
+	}
+	cmd := buildutil.GoCommandContext(ctx, ctxt, "go", "list", "-export", "-f", "{{.Export}}", "errors")
+	out, err := cmd.Output()
+	if err != nil {
+		return false // don't cache: the command may have been canceled
+	}
+	readable := false
+	if f, err := os.Open(strings.TrimSpace(string(out))); err == nil {
+		if r, err := gcexportdata.NewReader(f); err == nil {
+			_, err = gcexportdata.Read(r, token.NewFileSet(), make(map[string]*types.Package), "errors")
+			readable = err == nil
+		}
+		f.Close()
+	}
+	exportDataReadableCache.Store(ctxt.GOROOT, readable)
+	return readable
+}
+
+func (r *ModuleDiagnosticsRequest) diagnostics(ctx context.Context) (*ModuleDiagnosticsResponse, error) {
+	r.Dir = filepath.Clean(r.Dir)
+	ctxt := contextFromEnv(r.Env, r.Dir)
+	if tags := mgoBuildTags(ctxt, filepath.Join(r.Dir, "x.go")); len(tags) != 0 {
+		ctxt.BuildTags = append(ctxt.BuildTags, tags...)
+	}
+
+	mods, err := r.modules(ctx, ctxt)
+	if err != nil {
+		return nil, err
+	}
+	patterns := make([]string, len(mods))
+	for i, dir := range mods {
+		patterns[i] = dir + string(filepath.Separator) + "..."
+	}
+
+	res := &ModuleDiagnosticsResponse{
+		Modules: mods,
+		Files:   make(map[string][]CompileError),
+	}
+
+	buildRep := r.build(ctx, ctxt, patterns)
+	buildErrs := &compileErrorSet{files: make(map[string][]CompileError)}
+	for _, e := range buildRep.Errors {
+		if e.File == "" {
+			res.Errors = append(res.Errors, e.Message)
+			continue
+		}
+		buildErrs.files[e.File] = append(buildErrs.files[e.File], e)
+	}
+	if buildRep.TopLevelError != "" {
+		res.Errors = append(res.Errors, buildRep.TopLevelError)
+	}
+
+	// Dependencies are loaded from export data, or from source without
+	// function bodies for packages that fail to build. Export data written by
+	// a newer go command than x/tools supports cannot be read, in which case
+	// all dependencies have to be type checked from source.
+	mode := lintLoadMode
+	if !exportDataReadable(ctx, ctxt) {
+		mode |= packages.NeedDeps
+	}
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    mode,
+		Dir:     r.Dir,
+		Env:     buildutil.GoCommand(ctxt, "go").Env,
+		Tests:   true,
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	pkgs = modulePackages(pkgs)
+	res.Packages = len(pkgs)
+
+	var mu sync.Mutex
+	var g errgroup.Group
+	if r.Parallel > 0 {
+		g.SetLimit(r.Parallel)
+	} else {
+		g.SetLimit(runtime.NumCPU())
+	}
+	for _, pkg := range pkgs {
+		pkg := pkg
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			pd := r.analyze(pkg, buildErrs)
+			if r.Stream != "" {
+				sendCh <- Response{Token: r.Stream, Data: pd}
+			}
+			mu.Lock()
+			for name, errs := range pd.Files {
+				res.Files[name] = append(res.Files[name], errs...)
+			}
+			res.Errors = append(res.Errors, pd.Errors...)
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// build errors in files that were not loaded (e.g. excluded by
+	// build constraints)
+	for name, errs := range buildErrs.files {
+		res.Files[name] = append(res.Files[name], errs...)
+	}
+	for _, errs := range res.Files {
+		sort.SliceStable(errs, func(i, j int) bool {
+			if errs[i].Row != errs[j].Row {
+				return errs[i].Row < errs[j].Row
+			}
+			return errs[i].Col < errs[j].Col
+		})
+	}
+	return res, nil
+}
+
+func (r *ModuleDiagnosticsRequest) Call() (interface{}, string) {
+	if r.Dir == "" {
+		return &ModuleDiagnosticsResponse{}, "diagnostics_module: missing dir"
+	}
+	start := time.Now()
+	res, err := r.diagnostics(context.Background())
+	if res == nil {
+		res = &ModuleDiagnosticsResponse{}
+	}
+	logger.Named("diagnostics_module").Debug("diagnostics_module",
+		zap.String("dir", r.Dir), zap.Int("packages", res.Packages),
+		zap.Int("files", len(res.Files)), zap.Duration("duration", time.Since(start)))
+	return res, errStr(err)
+}
+
+func init() {
+	registry.Register("diagnostics_module", func(_ *Broker) Caller {
+		return &ModuleDiagnosticsRequest{Vet: true}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestModuleDiagnostics(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a/a.go": "package a\n\nfunc A() int { return \"a\" }\n",
+		"b/b.go": "package b\n\nimport \"fmt\"\n\nfunc B() { fmt.Printf(\"%d\\n\", \"b\") }\n",
+		"b/b_test.go": "package b\n\nimport \"testing\"\n\n" +
+			"func TestB(t *testing.T) { t.Errorf(\"%d\", \"b\") }\n",
+		"c/c.go": "package c\n",
+	})
+	r := &ModuleDiagnosticsRequest{Dir: filepath.Join(dir, "c"), Vet: true}
+	res, err := r.diagnostics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Modules) != 1 || res.Modules[0] != dir {
+		t.Errorf("Modules: got: %q want: %q", res.Modules, []string{dir})
+	}
+	if res.Packages != 3 {
+		t.Errorf("Packages: got: %d want: %d", res.Packages, 3)
+	}
+	tests := []struct {
+		file, category, analyzer string
+		row                      int
+	}{
+		{"a/a.go", CategoryCompile, "", 3},
+		{"b/b.go", CategoryVet, "printf", 5},
+		{"b/b_test.go", CategoryVet, "printf", 5},
+	}
+	for _, test := range tests {
+		errs := res.Files[filepath.Join(dir, test.file)]
+		if len(errs) != 1 {
+			t.Errorf("%s: expected 1 diagnostic got: %+v", test.file, errs)
+			continue
+		}
+		e := errs[0]
+		if e.Category != test.category || e.Analyzer != test.analyzer || e.Row != test.row {
+			t.Errorf("%s: unexpected diagnostic: %+v", test.file, e)
+		}
+	}
+	if len(res.Files) != len(tests) {
+		t.Errorf("expected diagnostics for %d files got: %+v", len(tests), res.Files)
+	}
+}
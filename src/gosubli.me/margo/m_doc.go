@@ -250,7 +250,10 @@ func (*FindRequest) updateFilename(ctxt *build.Context, filename string) (string
 	return filename, "", false
 }
 
-func contextFromEnv(env map[string]string) *build.Context {
+// contextFromEnv returns a copy of build.Default updated with env and the
+// active build config (see set_build_config) of the workspace containing
+// path, which may be empty.
+func contextFromEnv(env map[string]string, path string) *build.Context {
 	ctx := copyContext(&build.Default)
 	if s := env["GOARCH"]; s != "" {
 		ctx.GOARCH = s
@@ -258,10 +261,12 @@ func contextFromEnv(env map[string]string) *build.Context {
 	if s := env["GOOS"]; s != "" {
 		ctx.GOOS = s
 	}
-	if s := env["GOROOT"]; s != "" {
+	// ignore a GOROOT or GOPATH that no longer exists (e.g. the GOROOT of
+	// an uninstalled Go version) and use the default
+	if s := env["GOROOT"]; s != "" && isDir(s) {
 		ctx.GOROOT = s
 	}
-	if s := env["GOPATH"]; s != "" {
+	if s := existingDirs(env["GOPATH"]); s != "" {
 		ctx.GOPATH = s
 	}
 	if s := env["CGO_ENABLED"]; s != "" {
@@ -269,9 +274,39 @@ func contextFromEnv(env map[string]string) *build.Context {
 			ctx.CgoEnabled = enabled
 		}
 	}
+	ctx.BuildTags = append(ctx.BuildTags, goflagsTags(env["GOFLAGS"])...)
+	if conf := activeBuildConfig(path); conf != nil {
+		conf.apply(ctx)
+	}
 	return ctx
 }
 
+// existingDirs returns the directories of path list that exist.
+func existingDirs(list string) string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(list) {
+		if isDir(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return strings.Join(dirs, string(filepath.ListSeparator))
+}
+
+// goflagsTags returns the build tags of the "-tags" flag of GOFLAGS.
+func goflagsTags(goflags string) []string {
+	var tags []string
+	for _, f := range strings.Fields(goflags) {
+		for _, prefix := range []string{"-tags=", "--tags="} {
+			if strings.HasPrefix(f, prefix) {
+				tags = strings.FieldsFunc(f[len(prefix):], func(r rune) bool {
+					return r == ',' || r == ' '
+				})
+			}
+		}
+	}
+	return tags
+}
+
 func copyContext(orig *build.Context) *build.Context {
 	tmp := *orig // make a copy
 	ctxt := &tmp
@@ -284,7 +319,7 @@ func (f *FindRequest) Call() (interface{}, string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	parent := contextFromEnv(f.Env)
+	parent := contextFromEnv(f.Env, f.Fn)
 	name, fake, replaceRoot := f.updateFilename(parent, f.Fn)
 
 	ctxt, err := buildutil.MatchContext(parent, name, f.Src)
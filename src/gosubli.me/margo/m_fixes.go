@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"hash/fnv"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/charlievieth/imports"
+	"github.com/charlievieth/imports/gocommand"
+	"github.com/charlievieth/xtools/lsp/diff/myers"
+	"github.com/charlievieth/xtools/span"
+	"golang.org/x/tools/go/analysis"
+)
+
+// TextEdit replaces the source between Offset and End with NewText.
+//
+// Offset and End are byte offsets and are what apply_fix uses, Row, Col,
+// EndRow and EndCol are zero based and Col and EndCol are in runes.
+type TextEdit struct {
+	Row     int    `json:"row"`
+	Col     int    `json:"col"`
+	EndRow  int    `json:"end_row"`
+	EndCol  int    `json:"end_col"`
+	Offset  int    `json:"offset"`
+	End     int    `json:"end"`
+	NewText string `json:"new_text"`
+}
+
+// SuggestedFix is a named set of edits that fix a diagnostic.
+type SuggestedFix struct {
+	Message string     `json:"message"`
+	Edits   []TextEdit `json:"edits"`
+	// Hash is the sourceHash of the source the fix was computed for,
+	// apply_fix rejects the fix if the source has since changed.
+	Hash string `json:"hash,omitempty"`
+	// Import is resolved to Edits by apply_fix.
+	Import *ImportEdit `json:"import,omitempty"`
+}
+
+// ImportEdit is an import that apply_fix adds or removes.
+type ImportEdit struct {
+	Name string `json:"name,omitempty"` // undefined identifier to import
+	Path string `json:"path,omitempty"` // unused import to remove
+}
+
+// sourceHash returns the hash of src that fixes are checked against.
+func sourceHash(src []byte) string {
+	h := fnv.New64a()
+	h.Write(src)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// rowCol returns the zero based row and rune column of byte offset off.
+func rowCol(src []byte, off int) (row, col int) {
+	if off > len(src) {
+		off = len(src)
+	}
+	row = bytes.Count(src[:off], []byte{'\n'})
+	start := bytes.LastIndexByte(src[:off], '\n') + 1
+	return row, utf8.RuneCount(src[start:off])
+}
+
+func newTextEdit(src []byte, start, end int, text string) TextEdit {
+	e := TextEdit{Offset: start, End: end, NewText: text}
+	e.Row, e.Col = rowCol(src, start)
+	e.EndRow, e.EndCol = rowCol(src, end)
+	return e
+}
+
+// computeTextEdits returns the line based edits that convert before to after.
+func computeTextEdits(filename string, before, after []byte) []TextEdit {
+	edits, _ := myers.ComputeEdits(span.URIFromPath(filename), string(before), string(after))
+	if len(edits) == 0 {
+		return nil
+	}
+	// byte offset of the start of each line
+	lines := []int{0}
+	for i, c := range before {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	lineOffset := func(line int) int {
+		if line-1 < len(lines) {
+			return lines[line-1]
+		}
+		return len(before)
+	}
+	a := make([]TextEdit, len(edits))
+	for i, e := range edits {
+		start := lineOffset(e.Span.Start().Line())
+		end := lineOffset(e.Span.End().Line())
+		a[i] = newTextEdit(before, start, end, e.NewText)
+	}
+	return a
+}
+
+// applyTextEdits applies edits to src. The edits must not overlap.
+func applyTextEdits(src []byte, edits []TextEdit) ([]byte, error) {
+	edits = append([]TextEdit(nil), edits...)
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].Offset < edits[j].Offset
+	})
+	var buf bytes.Buffer
+	buf.Grow(len(src))
+	last := 0
+	for _, e := range edits {
+		if e.Offset < last || e.End < e.Offset || e.End > len(src) {
+			return nil, fmt.Errorf("invalid edit: offset: %d end: %d source length: %d",
+				e.Offset, e.End, len(src))
+		}
+		buf.Write(src[last:e.Offset])
+		buf.WriteString(e.NewText)
+		last = e.End
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), nil
+}
+
+// analysisFixes converts the suggested fixes of an analysis diagnostic into
+// SuggestedFixes. Fixes that edit files other than filename are ignored.
+func analysisFixes(fset *token.FileSet, filename string, src []byte, fixes []analysis.SuggestedFix) []SuggestedFix {
+	var a []SuggestedFix
+Fixes:
+	for _, fix := range fixes {
+		edits := make([]TextEdit, 0, len(fix.TextEdits))
+		for _, e := range fix.TextEdits {
+			tf := fset.File(e.Pos)
+			if tf == nil || tf.Name() != filename {
+				continue Fixes
+			}
+			end := e.End
+			if !end.IsValid() {
+				end = e.Pos
+			}
+			start, stop := tf.Offset(e.Pos), tf.Offset(end)
+			if stop > len(src) {
+				continue Fixes
+			}
+			edits = append(edits, newTextEdit(src, start, stop, string(e.NewText)))
+		}
+		if len(edits) != 0 {
+			a = append(a, SuggestedFix{Message: fix.Message, Edits: edits, Hash: sourceHash(src)})
+		}
+	}
+	return a
+}
+
+var (
+	unusedImportRe = regexp.MustCompile(`^"([^"]+)" imported (?:as \w+ )?and not used`)
+	undefinedRe    = regexp.MustCompile(`^undefined: (\w+)$`)
+)
+
+// addImportFixes adds fixes for missing and unused imports to the compile
+// errors in filename. The edits of the fixes are computed by apply_fix since
+// goimports may need to scan the module cache.
+func addImportFixes(filename string, src []byte, errs []CompileError) {
+	filename = filepath.Clean(filename)
+	hash := sourceHash(src)
+	for i := range errs {
+		e := &errs[i]
+		if e.File != filename {
+			continue
+		}
+		var fix SuggestedFix
+		if m := unusedImportRe.FindStringSubmatch(e.Message); m != nil {
+			fix.Message = fmt.Sprintf("Remove import: %q", m[1])
+			fix.Import = &ImportEdit{Path: m[1]}
+		} else if m := undefinedRe.FindStringSubmatch(e.Message); m != nil {
+			fix.Message = fmt.Sprintf("Add import for: %s", m[1])
+			fix.Import = &ImportEdit{Name: m[1]}
+		} else {
+			continue
+		}
+		fix.Hash = hash
+		e.Fixes = append(e.Fixes, fix)
+	}
+}
+
+// importEdits returns the edits that add or remove the import of imp.
+func importEdits(filename string, src []byte, imp *ImportEdit) ([]TextEdit, error) {
+	opts := &imports.Options{
+		TabWidth:  8,
+		TabIndent: true,
+		Comments:  true,
+		Fragment:  true,
+		Env: &imports.ProcessEnv{
+			GocmdRunner: &gocommand.Runner{},
+			WorkingDir:  filepath.Dir(filename),
+		},
+	}
+	fixes, err := imports.FixImports(filename, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fixes {
+		var match bool
+		if imp.Path != "" {
+			match = f.FixType == imports.DeleteImport && f.StmtInfo.ImportPath == imp.Path
+		} else {
+			match = f.FixType == imports.AddImport && f.IdentName == imp.Name
+		}
+		if !match {
+			continue
+		}
+		out, err := imports.ApplyFixes([]*imports.ImportFix{f}, filename, src, opts, 0)
+		if err != nil {
+			return nil, err
+		}
+		return computeTextEdits(filename, src, out), nil
+	}
+	if imp.Path != "" {
+		return nil, fmt.Errorf("unused import not found: %q", imp.Path)
+	}
+	return nil, fmt.Errorf("no import found for: %s", imp.Name)
+}
+
+type ApplyFixRequest struct {
+	Filename string       `json:"filename"`
+	Src      string       `json:"src"`
+	Fix      SuggestedFix `json:"fix"`
+}
+
+type ApplyFixResponse struct {
+	Src      string     `json:"src"`
+	Edits    []TextEdit `json:"edits"`
+	NoChange bool       `json:"no_change"`
+}
+
+func (r *ApplyFixRequest) Call() (interface{}, string) {
+	src := []byte(r.Src)
+	if r.Fix.Hash != "" && r.Fix.Hash != sourceHash(src) {
+		return &ApplyFixResponse{NoChange: true}, "apply_fix: source changed since the fix was computed"
+	}
+	fixEdits := r.Fix.Edits
+	if r.Fix.Import != nil {
+		if r.Filename == "" {
+			return &ApplyFixResponse{NoChange: true}, "apply_fix: missing filename"
+		}
+		var err error
+		fixEdits, err = importEdits(filepath.Clean(r.Filename), src, r.Fix.Import)
+		if err != nil {
+			return &ApplyFixResponse{NoChange: true}, "apply_fix: " + err.Error()
+		}
+	}
+	if len(fixEdits) == 0 {
+		return &ApplyFixResponse{NoChange: true}, "apply_fix: fix has no edits"
+	}
+	out, err := applyTextEdits(src, fixEdits)
+	if err != nil {
+		return &ApplyFixResponse{NoChange: true}, "apply_fix: " + err.Error()
+	}
+	if bytes.Equal(src, out) {
+		return &ApplyFixResponse{NoChange: true}, ""
+	}
+	edits := make([]TextEdit, len(fixEdits))
+	for i, e := range fixEdits {
+		edits[i] = newTextEdit(src, e.Offset, e.End, e.NewText)
+	}
+	return &ApplyFixResponse{Src: string(out), Edits: edits}, ""
+}
+
+func init() {
+	registry.Register("apply_fix", func(_ *Broker) Caller {
+		return &ApplyFixRequest{}
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestComputeTextEdits(t *testing.T) {
+	tests := []struct {
+		before, after string
+	}{
+		{"", ""},
+		{"a\nb\nc\n", "a\nb\nc\n"},
+		{"a\nb\nc\n", "a\nc\n"},
+		{"a\nb\nc\n", "a\nb\nx\ny\nc\n"},
+		{"a\nb\nc", "x\nb\nc\nd"},
+		{"import \"fmt\"\n\nfunc main() {}\n", "import (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc main() {}\n"},
+	}
+	for _, x := range tests {
+		edits := computeTextEdits("a.go", []byte(x.before), []byte(x.after))
+		got, err := applyTextEdits([]byte(x.before), edits)
+		if err != nil {
+			t.Errorf("applyTextEdits(%q, %+v): %v", x.before, edits, err)
+			continue
+		}
+		if string(got) != x.after {
+			t.Errorf("applyTextEdits(%q, %+v) = %q; want: %q", x.before, edits, got, x.after)
+		}
+	}
+}
+
+func TestRowCol(t *testing.T) {
+	src := []byte("a\nßb\n")
+	tests := []struct {
+		off, row, col int
+	}{
+		{0, 0, 0},
+		{1, 0, 1},
+		{2, 1, 0},
+		{4, 1, 1}, // 'ß' is two bytes
+		{5, 1, 2},
+		{6, 2, 0},
+	}
+	for _, x := range tests {
+		row, col := rowCol(src, x.off)
+		if row != x.row || col != x.col {
+			t.Errorf("rowCol(%q, %d) = %d, %d; want: %d, %d", src, x.off, row, col, x.row, x.col)
+		}
+	}
+}
+
+func TestApplyLintFix(t *testing.T) {
+	const src = `package lint
+
+import "fmt"
+
+func Hello(name string) {
+	fmt.Printf(name)
+}
+`
+	const want = `package lint
+
+import "fmt"
+
+func Hello(name string) {
+	fmt.Printf("%s", name)
+}
+`
+	dir := writeTestModule(t, map[string]string{"lint.go": src})
+	filename := filepath.Join(dir, "lint.go")
+
+	reports, err := (&mLint{Fn: JsonString(filename)}).lint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || len(reports[0].Fixes) == 0 {
+		t.Fatalf("expected 1 report with a fix got: %+v", reports)
+	}
+
+	req := ApplyFixRequest{Filename: filename, Src: src, Fix: reports[0].Fixes[0]}
+	v, errStr := req.Call()
+	if errStr != "" {
+		t.Fatal(errStr)
+	}
+	res := v.(*ApplyFixResponse)
+	if res.Src != want {
+		t.Errorf("got:\n%s\nwant:\n%s", res.Src, want)
+	}
+}
+
+func TestApplyImportFix(t *testing.T) {
+	const src = `package lint
+
+import "fmt"
+
+func Exit() {
+	os.Exit(1)
+}
+`
+	const want = `package lint
+
+import "os"
+
+func Exit() {
+	os.Exit(1)
+}
+`
+	dir := writeTestModule(t, map[string]string{"lint.go": src})
+	filename := filepath.Join(dir, "lint.go")
+	errs := []CompileError{
+		{File: filename, Message: `"fmt" imported and not used`},
+		{File: filename, Message: "undefined: os"},
+	}
+	addImportFixes(filename, []byte(src), errs)
+
+	out := src
+	for _, e := range errs {
+		if len(e.Fixes) != 1 || e.Fixes[0].Import == nil {
+			t.Fatalf("%s: expected an import fix got: %+v", e.Message, e.Fixes)
+		}
+		fix := e.Fixes[0]
+		fix.Hash = "" // the source changes after the first fix is applied
+		v, errStr := (&ApplyFixRequest{Filename: filename, Src: out, Fix: fix}).Call()
+		if errStr != "" {
+			t.Fatalf("%s: %s", e.Message, errStr)
+		}
+		out = v.(*ApplyFixResponse).Src
+	}
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	// fixes computed for a different source are rejected
+	stale := errs[0].Fixes[0]
+	if _, errStr := (&ApplyFixRequest{Filename: filename, Src: out, Fix: stale}).Call(); errStr == "" {
+		t.Error("expected an error applying a stale fix")
+	}
+}
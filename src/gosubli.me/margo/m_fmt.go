@@ -105,6 +105,10 @@ func (f *FormatRequest) callTimeout(key string) (*FormatResponse, error) {
 		Imports bool
 	}
 
+	if isModFile(f.Filename) {
+		return f.formatModFile()
+	}
+
 	src := []byte(f.Src)
 
 	// TODO: stop if there is an error here? We also might want
@@ -348,13 +352,18 @@ func (f *FormatRequest) cachePut(key string, res *FormatResponse, err error) (*F
 	return res, err
 }
 
-func (f *FormatRequest) Call() (interface{}, string) {
+// format runs the full format pipeline (goimports with a gofmt fallback)
+// and caches the result.
+func (f *FormatRequest) format() (*FormatResponse, error) {
 	log := logger.With(zap.String("filename", filepath.Base(f.Filename)))
 
 	key := fileCacheKey(f.Filename, f.Src)
 	if res, errStr, ok := f.cacheGet(key); ok {
 		log.Debug("format: cache hit")
-		return res, errStr
+		if errStr != "" {
+			return res, errors.New(errStr)
+		}
+		return res, nil
 	}
 
 	v, err, _ := formatRequestGroup.Do(key, func() (v interface{}, err error) {
@@ -375,10 +384,12 @@ func (f *FormatRequest) Call() (interface{}, string) {
 	if res == nil {
 		res = &FormatResponse{NoChange: true}
 	}
-	if err != nil {
-		return res, err.Error()
-	}
-	return res, ""
+	return res, err
+}
+
+func (f *FormatRequest) Call() (interface{}, string) {
+	res, err := f.format()
+	return res, errStr(err)
 }
 
 func init() {
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charlievieth/xtools/lsp/diff"
+	"github.com/charlievieth/xtools/lsp/diff/myers"
+	"github.com/charlievieth/xtools/span"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// FormatDirRequest formats all of the Go files in a directory using the same
+// pipeline as FormatRequest.
+type FormatDirRequest struct {
+	Dir       string   `json:"dir"`
+	Recursive bool     `json:"recursive"`
+	DryRun    bool     `json:"dry_run"`
+	Timeout   *float64 `json:"timeout"`
+}
+
+type FormatDirFile struct {
+	Filename string `json:"filename"`
+	Diff     string `json:"diff,omitempty"`  // unified diff (dry run only)
+	Error    string `json:"error,omitempty"` // only set for failed files
+}
+
+type FormatDirResponse struct {
+	Changed []FormatDirFile `json:"changed"`
+	Failed  []FormatDirFile `json:"failed"`
+}
+
+// skipFormatDir returns if the directory should not be formatted. It matches
+// the directories ignored by the go command and also skips vendor directories.
+func skipFormatDir(name string) bool {
+	switch name {
+	case "testdata", "vendor":
+		return true
+	}
+	return name != "" && (name[0] == '.' || name[0] == '_')
+}
+
+// isGeneratedFile returns if src contains a "Code generated ... DO NOT EDIT."
+// comment before its package clause.
+func isGeneratedFile(filename string, src []byte) bool {
+	fset := token.NewFileSet()
+	// ignore the error since a partial parse is fine here
+	af, _ := parser.ParseFile(fset, filename, src, parser.PackageClauseOnly|parser.ParseComments)
+	return af != nil && ast.IsGenerated(af)
+}
+
+func (r *FormatDirRequest) files() ([]string, error) {
+	root := filepath.Clean(r.Dir)
+	var names []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && (!r.Recursive || skipFormatDir(d.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if d.Type().IsRegular() && strings.HasSuffix(name, ".go") &&
+			name[0] != '.' && name[0] != '_' {
+			names = append(names, path)
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (r *FormatDirRequest) unifiedDiff(filename, before, after string) string {
+	edits, err := myers.ComputeEdits(span.URIFromPath(filename), before, after)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(diff.ToUnified(filename+".orig", filename, before, edits))
+}
+
+// formatFile formats filename and returns if it changed.
+func (r *FormatDirRequest) formatFile(filename string) (*FormatDirFile, bool, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	if isGeneratedFile(filename, src) {
+		return nil, false, nil
+	}
+	req := FormatRequest{
+		Filename:  filename,
+		Src:       string(src),
+		TabIndent: true,
+		Tabwidth:  8,
+		Timeout:   r.Timeout,
+	}
+	res, err := req.format()
+	if err != nil {
+		return nil, false, err
+	}
+	if res.NoChange || res.Src == "" || bytes.Equal(src, []byte(res.Src)) {
+		return nil, false, nil
+	}
+	file := &FormatDirFile{Filename: filename}
+	if r.DryRun {
+		file.Diff = r.unifiedDiff(filename, string(src), res.Src)
+		return file, true, nil
+	}
+	if err := os.WriteFile(filename, []byte(res.Src), fi.Mode().Perm()); err != nil {
+		return nil, false, err
+	}
+	return file, true, nil
+}
+
+func (r *FormatDirRequest) Call() (interface{}, string) {
+	res := &FormatDirResponse{
+		Changed: []FormatDirFile{},
+		Failed:  []FormatDirFile{},
+	}
+	if r.Dir == "" {
+		return res, "fmt_dir: missing directory"
+	}
+	if !isDir(r.Dir) {
+		return res, fmt.Sprintf("fmt_dir: not a directory: %q", r.Dir)
+	}
+	names, err := r.files()
+	if err != nil {
+		return res, err.Error()
+	}
+
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(runtime.NumCPU())
+	for _, name := range names {
+		name := name
+		g.Go(func() error {
+			file, changed, err := r.formatFile(name)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				res.Failed = append(res.Failed, FormatDirFile{
+					Filename: name,
+					Error:    err.Error(),
+				})
+			case changed:
+				res.Changed = append(res.Changed, *file)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	sort.Slice(res.Changed, func(i, j int) bool {
+		return res.Changed[i].Filename < res.Changed[j].Filename
+	})
+	sort.Slice(res.Failed, func(i, j int) bool {
+		return res.Failed[i].Filename < res.Failed[j].Filename
+	})
+
+	logger.Info("fmt_dir", zap.String("dir", r.Dir), zap.Int("files", len(names)),
+		zap.Int("changed", len(res.Changed)), zap.Int("failed", len(res.Failed)),
+		zap.Bool("dry_run", r.DryRun))
+
+	return res, ""
+}
+
+func init() {
+	registry.Register("fmt_dir", func(_ *Broker) Caller {
+		return &FormatDirRequest{Recursive: true}
+	})
+}
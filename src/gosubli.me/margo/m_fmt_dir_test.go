@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFormatDirTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFormatDirDryRun(t *testing.T) {
+	const unformatted = "package foo\n\nfunc  F( ) {\n}\n"
+	const generated = "// Code generated by test. DO NOT EDIT.\n\n" + unformatted
+
+	dir := writeFormatDirTree(t, map[string]string{
+		"a.go":             unformatted,
+		"gen.go":           generated,
+		"vendor/v/v.go":    unformatted,
+		"testdata/t.go":    unformatted,
+		"sub/b.go":         unformatted,
+		"sub/formatted.go": "package sub\n",
+	})
+
+	req := FormatDirRequest{Dir: dir, Recursive: true, DryRun: true}
+	v, errStr := req.Call()
+	if errStr != "" {
+		t.Fatal(errStr)
+	}
+	res := v.(*FormatDirResponse)
+	if len(res.Failed) != 0 {
+		t.Errorf("Failed: %+v", res.Failed)
+	}
+	want := []string{
+		filepath.Join(dir, "a.go"),
+		filepath.Join(dir, "sub", "b.go"),
+	}
+	if len(res.Changed) != len(want) {
+		t.Fatalf("Changed: got: %+v want: %q", res.Changed, want)
+	}
+	for i, f := range res.Changed {
+		if f.Filename != want[i] {
+			t.Errorf("Changed[%d]: got: %q want: %q", i, f.Filename, want[i])
+		}
+		if !strings.Contains(f.Diff, "+func F() {") {
+			t.Errorf("Changed[%d]: invalid diff:\n%s", i, f.Diff)
+		}
+	}
+
+	// dry run should not modify any files
+	data, err := os.ReadFile(filepath.Join(dir, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != unformatted {
+		t.Errorf("dry run modified file:\n%s", data)
+	}
+}
+
+func TestFormatDir(t *testing.T) {
+	const unformatted = "package foo\n\nfunc  F( ) {\n}\n"
+	const formatted = "package foo\n\nfunc F() {\n}\n"
+
+	dir := writeFormatDirTree(t, map[string]string{
+		"a.go":             unformatted,
+		"sub/b.go":         unformatted,
+		"sub/formatted.go": formatted,
+	})
+	// files that are already formatted must not be rewritten
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	formattedPath := filepath.Join(dir, "sub", "formatted.go")
+	if err := os.Chtimes(formattedPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	req := FormatDirRequest{Dir: dir, Recursive: true}
+	v, errStr := req.Call()
+	if errStr != "" {
+		t.Fatal(errStr)
+	}
+	res := v.(*FormatDirResponse)
+	if len(res.Failed) != 0 {
+		t.Errorf("Failed: %+v", res.Failed)
+	}
+	if len(res.Changed) != 2 {
+		t.Fatalf("Changed: got: %+v want: 2 files", res.Changed)
+	}
+	for _, name := range []string{"a.go", "sub/b.go"} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != formatted {
+			t.Errorf("%s: file not formatted:\n%s", name, data)
+		}
+	}
+
+	fi, err := os.Stat(formattedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(old) {
+		t.Errorf("formatted.go: file was rewritten: mtime: %s want: %s", fi.ModTime(), old)
+	}
+}
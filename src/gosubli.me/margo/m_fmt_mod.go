@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// isModFile returns if filename is a go.mod or go.work file.
+func isModFile(filename string) bool {
+	switch filepath.Base(filename) {
+	case "go.mod", "go.work":
+		return true
+	}
+	return false
+}
+
+// modFileError converts the errors returned by the modfile package into a
+// scanner.ErrorList so that they are reported like Go syntax errors.
+func modFileError(err error) error {
+	var list modfile.ErrorList
+	if !errors.As(err, &list) {
+		var e *modfile.Error
+		if !errors.As(err, &e) {
+			return err
+		}
+		list = modfile.ErrorList{*e}
+	}
+	var errs scanner.ErrorList
+	for _, e := range list {
+		pos := token.Position{
+			Filename: e.Filename,
+			Line:     e.Pos.Line,
+			Column:   e.Pos.LineRune,
+		}
+		if pos.Column == 0 {
+			pos.Column = 1
+		}
+		// clear the position so that Error only returns the message
+		e.Filename = ""
+		e.Pos = modfile.Position{}
+		errs.Add(pos, e.Error())
+	}
+	if len(errs) == 0 {
+		return err
+	}
+	errs.Sort()
+	return errs.Err()
+}
+
+// dedupeRequire returns the requirements of f with at most one entry per
+// module path (the highest version) and if any duplicates were found.
+func dedupeRequire(f *modfile.File) ([]*modfile.Require, bool) {
+	seen := make(map[string]*modfile.Require, len(f.Require))
+	reqs := make([]*modfile.Require, 0, len(f.Require))
+	dupes := false
+	for _, r := range f.Require {
+		prev, ok := seen[r.Mod.Path]
+		if !ok {
+			req := &modfile.Require{Mod: r.Mod, Indirect: r.Indirect}
+			seen[r.Mod.Path] = req
+			reqs = append(reqs, req)
+			continue
+		}
+		dupes = true
+		if semver.Compare(r.Mod.Version, prev.Mod.Version) > 0 {
+			prev.Mod.Version = r.Mod.Version
+		}
+		// a module is only indirect if every requirement on it is
+		prev.Indirect = prev.Indirect && r.Indirect
+	}
+	return reqs, dupes
+}
+
+func formatGoMod(filename string, src []byte) ([]byte, error) {
+	f, err := modfile.Parse(filename, src, nil)
+	if err != nil {
+		return nil, modFileError(err)
+	}
+	if reqs, dupes := dedupeRequire(f); dupes {
+		f.SetRequire(reqs)
+	}
+	f.SortBlocks()
+	f.Cleanup()
+	return modfile.Format(f.Syntax), nil
+}
+
+func formatGoWork(filename string, src []byte) ([]byte, error) {
+	f, err := modfile.ParseWork(filename, src, nil)
+	if err != nil {
+		return nil, modFileError(err)
+	}
+	seen := make(map[string]int, len(f.Use))
+	for _, u := range f.Use {
+		seen[u.Path]++
+	}
+	for _, u := range f.Use {
+		if seen[u.Path] > 1 {
+			path, modpath := u.Path, u.ModulePath
+			f.DropUse(path)
+			f.AddNewUse(path, modpath)
+			seen[path] = 1
+		}
+	}
+	f.SortBlocks()
+	f.Cleanup()
+	return modfile.Format(f.Syntax), nil
+}
+
+// formatModFile formats go.mod and go.work files using the modfile package.
+func (f *FormatRequest) formatModFile() (*FormatResponse, error) {
+	src := []byte(f.Src)
+	var out []byte
+	var err error
+	if filepath.Base(f.Filename) == "go.work" {
+		out, err = formatGoWork(f.Filename, src)
+	} else {
+		out, err = formatGoMod(f.Filename, src)
+	}
+	if err != nil {
+		return &FormatResponse{NoChange: true}, err
+	}
+	if bytes.Equal(src, out) {
+		return &FormatResponse{NoChange: true}, nil
+	}
+	return &FormatResponse{Src: string(out)}, nil
+}
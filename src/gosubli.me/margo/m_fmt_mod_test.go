@@ -0,0 +1,93 @@
+package main
+
+import (
+	"go/scanner"
+	"testing"
+)
+
+func TestFormatGoMod(t *testing.T) {
+	const src = `module example.com/foo
+
+go 1.22
+
+require (
+	golang.org/x/sync v0.8.0
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/mod v0.20.0
+)
+
+require golang.org/x/mod   v0.21.0
+`
+	const want = `module example.com/foo
+
+go 1.22
+
+require (
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/mod v0.21.0
+	golang.org/x/sync v0.8.0
+)
+`
+	req := FormatRequest{Filename: "/x/go.mod", Src: src}
+	res, err := req.formatModFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NoChange {
+		t.Fatal("expected go.mod to change")
+	}
+	if res.Src != want {
+		t.Errorf("got:\n%s\nwant:\n%s", res.Src, want)
+	}
+
+	req.Src = want
+	res, err = req.formatModFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.NoChange {
+		t.Errorf("formatted go.mod changed:\n%s", res.Src)
+	}
+}
+
+func TestFormatGoWork(t *testing.T) {
+	const src = `go 1.22
+
+use (
+	./b
+	./a
+	./b
+)
+`
+	const want = `go 1.22
+
+use (
+	./a
+	./b
+)
+`
+	req := FormatRequest{Filename: "/x/go.work", Src: src}
+	res, err := req.formatModFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Src != want {
+		t.Errorf("got:\n%s\nwant:\n%s", res.Src, want)
+	}
+}
+
+func TestFormatGoModError(t *testing.T) {
+	const src = "module example.com/foo\n\nrequire golang.org/x/mod\n"
+	req := FormatRequest{Filename: "/x/go.mod", Src: src}
+	_, err := req.formatModFile()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		t.Fatalf("error type: got: %T want: %T", err, scanner.ErrorList{})
+	}
+	if pos := list[0].Pos; pos.Filename != "/x/go.mod" || pos.Line != 3 {
+		t.Errorf("error position: got: %s want: %s", pos, "/x/go.mod:3:1")
+	}
+}
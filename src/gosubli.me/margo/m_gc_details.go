@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// GCDetailsRequest builds the package containing Filename with the compiler
+// optimization output enabled (-gcflags=-m) and returns the escape analysis,
+// inlining and bounds check decisions of the compiler.
+type GCDetailsRequest struct {
+	Filename string            `json:"filename"`
+	Src      string            `json:"src,omitempty"`
+	Overlay  map[string]string `json:"overlay,omitempty"`
+
+	// The kinds of diagnostics to report, if none are set all are
+	// reported.
+	Escape      bool `json:"escape"`
+	Inline      bool `json:"inline"`
+	BoundsCheck bool `json:"bounds_check"`
+}
+
+// Optimization diagnostic kinds.
+const (
+	OptimizationEscape = "escape"
+	OptimizationInline = "inline"
+	OptimizationBounds = "bounds"
+)
+
+type OptimizationDiagnostic struct {
+	Row     int    `json:"row"`
+	Col     int    `json:"col"`
+	File    string `json:"file"`
+	Message string `json:"message"`
+	Kind    string `json:"kind"`
+}
+
+type GCDetailsResponse struct {
+	Filename    string                   `json:"filename"`
+	Diagnostics []OptimizationDiagnostic `json:"diagnostics"`
+
+	// Errors are set if the package failed to build.
+	Errors   []CompileError `json:"errors,omitempty"`
+	CmdError string         `json:"cmd_error,omitempty"`
+}
+
+// optimizationKind returns the kind of the compiler optimization message msg
+// or an empty string if msg is not one (e.g. a compile error).
+func optimizationKind(msg string) string {
+	switch {
+	case strings.HasPrefix(msg, "can inline "),
+		strings.HasPrefix(msg, "cannot inline "),
+		strings.HasPrefix(msg, "inlining call to "):
+		return OptimizationInline
+	case strings.HasPrefix(msg, "Found IsInBounds"),
+		strings.HasPrefix(msg, "Found IsSliceInBounds"):
+		return OptimizationBounds
+	case strings.Contains(msg, "escapes to heap"),
+		strings.Contains(msg, "does not escape"),
+		strings.HasPrefix(msg, "leaking param"),
+		strings.HasPrefix(msg, "leaking closure"),
+		strings.HasPrefix(msg, "moved to heap: "):
+		return OptimizationEscape
+	}
+	return ""
+}
+
+func (r *GCDetailsRequest) kinds() map[string]bool {
+	if !r.Escape && !r.Inline && !r.BoundsCheck {
+		return map[string]bool{
+			OptimizationEscape: true,
+			OptimizationInline: true,
+			OptimizationBounds: true,
+		}
+	}
+	return map[string]bool{
+		OptimizationEscape: r.Escape,
+		OptimizationInline: r.Inline,
+		OptimizationBounds: r.BoundsCheck,
+	}
+}
+
+// gcflags returns the -gcflags build flag for the requested diagnostics.
+func (r *GCDetailsRequest) gcflags() string {
+	kinds := r.kinds()
+	var flags []string
+	if kinds[OptimizationEscape] || kinds[OptimizationInline] {
+		flags = append(flags, "-m")
+	}
+	if kinds[OptimizationBounds] {
+		flags = append(flags, "-d=ssa/check_bce/debug=1")
+	}
+	return "-gcflags=" + strings.Join(flags, " ")
+}
+
+// ParseGCDetails parses the output of building a package with -gcflags=-m
+// into optimization diagnostics and returns the remaining lines, which are
+// build errors and their "# pkg" headers. Overlay maps replacement files to
+// the files they replace.
+func ParseGCDetails(dirname string, overlay map[string]string, kinds map[string]bool, out []byte) ([]OptimizationDiagnostic, []string) {
+	type key struct {
+		file     string
+		row, col int
+		msg      string
+	}
+	seen := make(map[key]bool)
+	var diags []OptimizationDiagnostic
+	var other []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		m := compRe.FindStringSubmatch(line)
+		if m == nil {
+			other = append(other, line)
+			continue
+		}
+		kind := optimizationKind(m[4])
+		if kind == "" {
+			other = append(other, line)
+			continue
+		}
+		if !kinds[kind] {
+			continue
+		}
+		file := m[1]
+		if !filepath.IsAbs(file) && dirname != "" {
+			file = filepath.Join(dirname, file)
+		}
+		if s, ok := overlay[file]; ok {
+			file = s
+		}
+		row, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		// generic functions and test variants may report the same
+		// position more than once
+		k := key{file, row, col, m[4]}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		diags = append(diags, OptimizationDiagnostic{
+			Row:     row,
+			Col:     col,
+			File:    file,
+			Message: m[4],
+			Kind:    kind,
+		})
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		d1, d2 := &diags[i], &diags[j]
+		if d1.File != d2.File {
+			return d1.File < d2.File
+		}
+		if d1.Row != d2.Row {
+			return d1.Row < d2.Row
+		}
+		return d1.Col < d2.Col
+	})
+	return diags, other
+}
+
+func (r *GCDetailsRequest) details(ctx context.Context) (*GCDetailsResponse, error) {
+	src := []byte(r.Src)
+	if r.Src == "" {
+		var err error
+		if src, err = ioutil.ReadFile(r.Filename); err != nil {
+			return nil, err
+		}
+	}
+	c := &CompLintRequest{Filename: r.Filename, Src: r.Src, Overlay: r.Overlay}
+	ctxt := fileBuildContext(r.Filename, src)
+
+	args := []string{"build", "-o", os.DevNull}
+	if strings.HasSuffix(r.Filename, "_test.go") {
+		args = []string{"test", "-c", "-o", os.DevNull}
+	}
+	args = append(args, r.gcflags())
+	overlay, replaced, cleanup, err := c.writeOverlay(src)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if overlay != "" {
+		args = append(args, overlay)
+	}
+
+	cmd := c.goCommand(ctx, ctxt, args...)
+	out, err := cmd.CombinedOutput()
+	res := &GCDetailsResponse{Filename: r.Filename}
+	var other []string
+	res.Diagnostics, other = ParseGCDetails(cmd.Dir, replaced, r.kinds(), out)
+	if err != nil {
+		res.CmdError = err.Error()
+		rep := &CompLintReport{}
+		rep.ParseErrors(cmd.Dir, []byte(strings.Join(other, "\n")))
+		res.Errors = rep.Errors
+		if rep.TopLevelError != "" {
+			res.CmdError = rep.TopLevelError
+		}
+	}
+	return res, nil
+}
+
+func (r *GCDetailsRequest) Call() (interface{}, string) {
+	if r.Filename == "" {
+		return &GCDetailsResponse{}, "gc_details: missing filename"
+	}
+	r.Filename = filepath.Clean(r.Filename)
+	start := time.Now()
+	res, err := r.details(context.Background())
+	if err != nil {
+		return &GCDetailsResponse{Filename: r.Filename, CmdError: err.Error()}, err.Error()
+	}
+	logger.Named("gc_details").Debug("gc_details", zap.String("filename", r.Filename),
+		zap.Int("diagnostics", len(res.Diagnostics)), zap.Duration("duration", time.Since(start)))
+	return res, ""
+}
+
+func init() {
+	registry.Register("gc_details", func(_ *Broker) Caller {
+		return &GCDetailsRequest{}
+	})
+}
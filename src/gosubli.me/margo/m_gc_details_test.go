@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestGCDetails(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a/a.go": `package a
+
+func add(a, b int) int { return a + b }
+
+func Sum(a []int) int { return add(a[0], a[1]) }
+
+func New() *int {
+	x := 1
+	return &x
+}
+`,
+	})
+	r := &GCDetailsRequest{Filename: filepath.Join(dir, "a", "a.go")}
+	res, err := r.details(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CmdError != "" || len(res.Errors) != 0 {
+		t.Fatalf("unexpected build error: %s: %+v", res.CmdError, res.Errors)
+	}
+	want := map[OptimizationDiagnostic]bool{
+		{Row: 3, Col: 6, File: r.Filename, Message: "can inline add", Kind: OptimizationInline}:        false,
+		{Row: 5, Col: 35, File: r.Filename, Message: "inlining call to add", Kind: OptimizationInline}: false,
+		{Row: 5, Col: 37, File: r.Filename, Message: "Found IsInBounds", Kind: OptimizationBounds}:     false,
+		{Row: 8, Col: 2, File: r.Filename, Message: "moved to heap: x", Kind: OptimizationEscape}:      false,
+		{Row: 5, Col: 10, File: r.Filename, Message: "a does not escape", Kind: OptimizationEscape}:    false,
+		{Row: 7, Col: 6, File: r.Filename, Message: "can inline New", Kind: OptimizationInline}:        false,
+		{Row: 5, Col: 6, File: r.Filename, Message: "can inline Sum", Kind: OptimizationInline}:        false,
+		{Row: 5, Col: 43, File: r.Filename, Message: "Found IsInBounds", Kind: OptimizationBounds}:     false,
+	}
+	for _, d := range res.Diagnostics {
+		if _, ok := want[d]; ok {
+			want[d] = true
+		}
+	}
+	for d, found := range want {
+		if !found {
+			t.Errorf("missing diagnostic: %+v", d)
+		}
+	}
+	if t.Failed() {
+		t.Logf("diagnostics: %+v", res.Diagnostics)
+	}
+
+	// only report the requested kinds
+	r.Escape = true
+	res, err = r.details(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range res.Diagnostics {
+		if d.Kind != OptimizationEscape {
+			t.Errorf("unexpected diagnostic: %+v", d)
+		}
+	}
+
+	// build errors are reported
+	r.Src = "package a\n\nfunc F() int { return \"\" }\n"
+	res, err = r.details(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Row != 3 {
+		t.Errorf("expected 1 build error got: %+v", res.Errors)
+	}
+}
+
+func TestOptimizationKind(t *testing.T) {
+	tests := []struct {
+		msg, kind string
+	}{
+		{"&T{} escapes to heap", OptimizationEscape},
+		{"x escapes to heap:", OptimizationEscape},
+		{"a does not escape", OptimizationEscape},
+		{"leaking param: p", OptimizationEscape},
+		{"leaking param content: p", OptimizationEscape},
+		{"leaking closure reference x", OptimizationEscape},
+		{"moved to heap: x", OptimizationEscape},
+		{"can inline f", OptimizationInline},
+		{"Found IsInBounds", OptimizationBounds},
+		// compile errors
+		{"undefined: escapeHTML", ""},
+		{"leakyBucket declared and not used", ""},
+		{"cannot use escape (variable of type int) as string value", ""},
+	}
+	for _, x := range tests {
+		if kind := optimizationKind(x.msg); kind != x.kind {
+			t.Errorf("optimizationKind(%q) = %q; want: %q", x.msg, kind, x.kind)
+		}
+	}
+}
@@ -1,15 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"go/ast"
 	"go/build"
-	"go/parser"
-	"go/token"
-	"io"
-	"io/ioutil"
+	"go/types"
 	"log"
 	"os"
 	"path/filepath"
@@ -19,8 +14,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/charlievieth/buildutil/contextutil"
 	"github.com/charlievieth/gocode"
-	"github.com/mdempsky/gocode/pkg/cache"
 	"github.com/mdempsky/gocode/pkg/suggest"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -39,7 +34,9 @@ type GoCode struct {
 	Fn            string
 	Src           string
 	Pos           int
-	calltip       bool `json:"-"` // ignore
+	// PlainText inserts the name of candidates instead of a snippet.
+	PlainText bool
+	calltip   bool `json:"-"` // ignore
 }
 
 // Separate type so we can init Calltip to true
@@ -51,8 +48,6 @@ func (g *GoCodeCalltip) Init() {
 	g.calltip = true
 }
 
-var calltipCache = NewAstCache(50)
-
 func init() {
 	registry.Register("gocode_complete", func(b *Broker) Caller {
 		return &GoCode{}
@@ -64,7 +59,29 @@ func init() {
 }
 
 type GoCodeResponse struct {
-	Candidates []suggest.Candidate
+	Candidates []GoCodeCandidate
+}
+
+// GoCodeCandidate is a completion candidate along with the edits that are
+// applied when it is selected, such as adding the import of its package.
+type GoCodeCandidate struct {
+	suggest.Candidate
+	// InsertText is the snippet, or name if plain text was requested, that
+	// is inserted for the candidate.
+	InsertText      string     `json:"insert_text,omitempty"`
+	AdditionalEdits []TextEdit `json:"additional_edits,omitempty"`
+	// Doc is the summary of the doc comment of the candidate and Deprecated
+	// reports if it is deprecated, both are only set by completion_resolve.
+	Doc        string `json:"doc,omitempty"`
+	Deprecated bool   `json:"deprecated,omitempty"`
+}
+
+func newGoCodeCandidates(cl []suggest.Candidate) []GoCodeCandidate {
+	a := make([]GoCodeCandidate, len(cl))
+	for i, c := range cl {
+		a[i] = GoCodeCandidate{Candidate: c}
+	}
+	return a
 }
 
 var lastCalltip struct {
@@ -131,6 +148,51 @@ func initGocodeConfig() (*suggest.Config, error) {
 	return suggestConfig, initGocodeConfigErr
 }
 
+// gocodeImporters caches the completion importer of each build context.
+var gocodeImporters = lru.New(16)
+
+// buildContext returns the build.Context of the file being completed: the
+// request environment matched to the file (see envBuildContext).
+func (g *GoCode) buildContext() *build.Context {
+	ctxt := envBuildContext(g.Env, g.filepath(), []byte(g.Src))
+	if g.InstallSuffix != "" {
+		ctxt.InstallSuffix = g.InstallSuffix
+	}
+	return ctxt
+}
+
+// moduleRoot returns the root of the module containing the file being
+// completed, if any.
+func (g *GoCode) moduleRoot(ctxt *build.Context) string {
+	root, err := contextutil.ContainingDirectory(ctxt, filepath.Dir(g.filepath()), "", "go.mod")
+	if err != nil {
+		return ""
+	}
+	return root
+}
+
+// importerKey returns the gocodeImporters key of ctxt and the module root
+// since imports are resolved relative to it.
+func importerKey(ctxt *build.Context, root string) string {
+	return contextKey(ctxt) + "|" + ctxt.InstallSuffix + "|" + root
+}
+
+// importer returns the long-lived completion importer for ctxt.
+func (g *GoCode) importer(ctxt *build.Context) types.ImporterFrom {
+	root := g.moduleRoot(ctxt)
+	key := importerKey(ctxt, root)
+	if v, ok := gocodeImporters.Get(key); ok {
+		return v.(*gocodeImporter)
+	}
+	logf := noopLogger
+	if GocodeDebugLogger {
+		logf = g.newStdLog(logger.Named("gocode").Named("cache"), zap.InfoLevel).Printf
+	}
+	imp := newGocodeImporter(ctxt, root, logf)
+	gocodeImporters.Add(key, imp)
+	return imp
+}
+
 func (*GoCode) newStdLog(log *zap.Logger, lvl zapcore.Level) *log.Logger {
 	std, err := zap.NewStdLogAt(log, lvl)
 	if err != nil {
@@ -141,7 +203,7 @@ func (*GoCode) newStdLog(log *zap.Logger, lvl zapcore.Level) *log.Logger {
 	return std
 }
 
-func (g *GoCode) doCall() (res []suggest.Candidate, err error) {
+func (g *GoCode) doCall(ctxt *build.Context) (res []suggest.Candidate, err error) {
 	start := time.Now()
 	cursor, err := g.bytePos()
 	if err != nil {
@@ -172,25 +234,16 @@ func (g *GoCode) doCall() (res []suggest.Candidate, err error) {
 
 		}
 	}()
-	cfg := suggest.Config{
-		Builtin:            true,
-		IgnoreCase:         true,
-		UnimportedPackages: false,
-		Logf:               noopLogger,
-	}
-	if GocodeDebugLogger {
-		cfg.Logf = g.newStdLog(log.Named("suggest"), zap.InfoLevel).Printf
+	base, err := initGocodeConfig()
+	if err != nil {
+		return nil, err
 	}
+	cfg := *base
 
 	// TODO: record completion time as a histogram and print
 	// the relevent percentiles every N completion requests
 
-	ctxt := build.Default
-	if GocodeDebugLogger {
-		cfg.Importer = cache.NewIImporter(&ctxt, g.newStdLog(log.Named("cache"), zap.InfoLevel).Printf)
-	} else {
-		cfg.Importer = cache.NewIImporter(&ctxt, noopLogger)
-	}
+	cfg.Importer = g.importer(ctxt)
 
 	candidates, d := cfg.Suggest(g.Fn, []byte(g.Src), cursor)
 	_ = d
@@ -208,7 +261,7 @@ func (g *GoCode) doCall() (res []suggest.Candidate, err error) {
 	return candidates, nil
 }
 
-func (g *GoCode) doCalltips() ([]suggest.Candidate, error) {
+func (g *GoCode) doCalltips(ctxt *build.Context) ([]suggest.Candidate, error) {
 	cursor, err := g.bytePos()
 	if err != nil {
 		return nil, err
@@ -217,26 +270,43 @@ func (g *GoCode) doCalltips() ([]suggest.Candidate, error) {
 	// we guard against that in the Python code.
 	path := g.filepath()
 	if res, ok := useLastCalltip(path, g.Src, cursor); ok {
-		return res.Candidates, nil
+		cl := make([]suggest.Candidate, len(res.Candidates))
+		for i, c := range res.Candidates {
+			cl[i] = c.Candidate
+		}
+		return cl, nil
 	}
-	cl, err := g.calltips(path, []byte(g.Src), cursor)
-	setLastCalltip(path, g.Src, cursor, GoCodeResponse{Candidates: cl})
+	cl, err := g.calltips(ctxt, path, []byte(g.Src), cursor)
+	setLastCalltip(path, g.Src, cursor, GoCodeResponse{Candidates: newGoCodeCandidates(cl)})
 	return cl, err
 }
 
 func (g *GoCode) Call() (response interface{}, errStr string) {
+	ctxt := g.buildContext()
+	if !g.calltip {
+		if cl, ok := g.contextCandidates(ctxt); ok {
+			return GoCodeResponse{Candidates: cl}, ""
+		}
+	}
 	var candidates []suggest.Candidate
 	var err error
 	if g.calltip {
-		candidates, err = g.doCalltips()
+		candidates, err = g.doCalltips(ctxt)
 	} else {
-		candidates, err = g.doCall()
+		candidates, err = g.doCall(ctxt)
 	}
 	if err != nil {
 		return GoCodeResponse{NoGocodeCandidates}, err.Error()
 	}
+	if g.calltip {
+		return GoCodeResponse{Candidates: newGoCodeCandidates(candidates)}, ""
+	}
+	res := g.unimportedCandidates(ctxt, candidates)
+	g.addSnippets(res)
+	res = append(res, g.postfixCandidates(ctxt)...)
+	res = append(res, g.fillStructCandidates(ctxt)...)
 	// TODO: use a pointer
-	return GoCodeResponse{Candidates: candidates}, ""
+	return GoCodeResponse{Candidates: res}, ""
 }
 
 // WARN: dev only
@@ -375,7 +445,7 @@ func (g *GoCode) Call_OLD() (interface{}, string) {
 		if res, ok := useLastCalltip(path, g.Src, off); ok {
 			return res, ""
 		}
-		gr, err := g.calltips(path, []byte(g.Src), off)
+		gr, err := g.calltips(g.buildContext(), path, []byte(g.Src), off)
 		res, errStr := g.response(gr, err, false)
 		setLastCalltip(path, g.Src, off, res)
 		return res, errStr
@@ -396,7 +466,7 @@ func (g *GoCode) Call_OLD() (interface{}, string) {
 		).With(zap.String("filename", g.shortFilename()), zap.Int("cursor", g.Pos))
 
 		start := time.Now()
-		candidates, err := g.doCall()
+		candidates, err := g.doCall(g.buildContext())
 		if err != nil {
 			log.Error("gocode: response error", zap.Error(err),
 				zap.String("line", extractCursorLine(g.Src, g.Pos)))
@@ -454,7 +524,7 @@ func (g *GoCode) Call_OLD() (interface{}, string) {
 	return g.response(cl, nil, true)
 }
 
-var NoGocodeCandidates = []suggest.Candidate{}
+var NoGocodeCandidates = []GoCodeCandidate{}
 
 func (g *GoCode) response(res []suggest.Candidate, err error, install bool) (GoCodeResponse, string) {
 	if res == nil || len(res) == 0 {
@@ -465,13 +535,12 @@ func (g *GoCode) response(res []suggest.Candidate, err error, install bool) (GoC
 				InstallSuffix: g.InstallSuffix,
 			})
 		}
-		res = NoGocodeCandidates
 	}
 	var errStr string
 	if err != nil {
 		errStr = err.Error()
 	}
-	return GoCodeResponse{Candidates: res}, errStr
+	return GoCodeResponse{Candidates: newGoCodeCandidates(res)}, errStr
 }
 
 // Matching GoSublime's behavior here...
@@ -522,135 +591,19 @@ func (g *GoCode) GOROOT() string {
 	return runtime.GOROOT()
 }
 
-// TODO: cache results and potentially cache AST ad file set.
-func (g *GoCode) calltips(filename string, src []byte, cursor int) ([]suggest.Candidate, error) {
-	// WARN WARN WARN
-	// for i := cursor; i > 0; i-- {
-	// 	c := src[cursor]
-	// 	if c == ' ' || c == '.' {
-	// 		cursor = i + 1
-	// 		break
-	// 	}
-	// }
-	//
-	// for ; cursor > 0; cursor-- {
-	// 	c := src[cursor]
-	// 	if c == ' ' || c == '.' {
-	// 		break
-	// 	}
-	// }
-	//
-
-	fset, af, err := calltipCache.ParseFile(filename, src)
-	if af == nil {
-		return nil, err
-	}
-	pos, err := g.convertOffset(af, fset, cursor)
-	if err != nil {
-		return nil, err
-	}
-	id, err := g.findCaller(af, fset, pos)
+// calltips returns the signature of the call enclosing cursor as a gocode
+// candidate.
+func (g *GoCode) calltips(ctxt *build.Context, filename string, src []byte, cursor int) ([]suggest.Candidate, error) {
+	sig, err := signatureHelp(ctxt, g.importer(ctxt), filename, src, cursor)
 	if err != nil {
 		return nil, err
 	}
-	end := fset.Position(id.End())
-	if !end.IsValid() {
-		return nil, errors.New("calltip: invalid end pos")
-	}
-	if err := g.validLine(fset, pos, end); err != nil {
-		return nil, err
-	}
-	cfg := suggest.Config{
-		Builtin:            true,
-		IgnoreCase:         true,
-		UnimportedPackages: false,
-		Logf:               noopLogger,
-	}
-	cl, _ := cfg.Suggest(g.Fn, []byte(g.Src), cursor)
-
-	// // WARN WARN WARN
-	// {
-	// 	typs := make([]string, len(cl))
-	// 	for i, c := range cl {
-	// 		typs[i] = c.String()
-	// 	}
-	// 	// line := extractCursorLine(string(src), cursor)
-	// 	logger.Named("gocode_calltips").With(
-	// 		zap.String("filename", g.shortFilename()), zap.Int("cursor", cursor),
-	// 		zap.String("ident", fmt.Sprintf("%s", id.Name)),
-	// 		// zap.String("line", line),
-	// 	).Warn("calltip candidates", zap.Strings("candidates", typs))
-	// }
-
-	for i := 0; i < len(cl); i++ {
-		if strings.EqualFold(id.Name, cl[i].Name) {
-			return cl[i : i+1], nil
-		}
-	}
-	return nil, errors.New("calltip: no candidates")
-}
-
-// Matching GoSublime's behavior here, see if this can be removed.
-func (g *GoCode) validLine(fset *token.FileSet, off token.Pos, end token.Position) error {
-	line := fset.Position(off).Line
-	if end.Line == line || line == 0 {
-		return nil
-	}
-	return fmt.Errorf("calltip: line mismatch end (%d) line (%d)", end.Line, line)
-}
-
-func (g *GoCode) convertOffset(af *ast.File, fset *token.FileSet, offset int) (token.Pos, error) {
-	f := fset.File(af.Pos())
-	if f == nil {
-		return token.NoPos, errors.New("calltip: ast file not in fileset")
-	}
-	// Prevent Pos from panicking
-	if offset > f.Size() {
-		return token.NoPos, errors.New("calltip: illegal file offset")
-	}
-	return f.Pos(offset), nil
-}
-
-func (g *GoCode) findCaller(af *ast.File, fset *token.FileSet, pos token.Pos) (*ast.Ident, error) {
-	v := calltipVisitor{
-		pos:  pos,
-		fset: fset,
-	}
-	ast.Walk(&v, af)
-	if v.expr == nil || v.expr.Fun == nil {
-		return nil, errors.New("calltip: nil CallExpr")
-	}
-	switch x := v.expr.Fun.(type) {
-	case *ast.Ident:
-		return x, nil
-	case *ast.SelectorExpr:
-		return x.Sel, nil
-	}
-	return nil, errors.New("calltip: invalid CallExpr.Fun")
-}
-
-// calltipVisitor satisfies the ast.Visitor interface.
-type calltipVisitor struct {
-	pos  token.Pos
-	fset *token.FileSet
-	expr *ast.CallExpr
-}
-
-func (v *calltipVisitor) Visit(node ast.Node) (w ast.Visitor) {
-	if node == nil {
-		return v
-	}
-	if x, ok := node.(*ast.CallExpr); ok {
-		pos := x.Pos()
-		end := x.End()
-		switch {
-		case pos <= v.pos && v.pos <= end:
-			v.expr = x
-		case pos > v.pos:
-			return nil
-		}
-	}
-	return v
+	return []suggest.Candidate{{
+		Class:   "func",
+		PkgPath: sig.Package,
+		Name:    sig.Name,
+		Type:    "func" + sig.signature(),
+	}}, nil
 }
 
 func (g *GoCode) bytePos() (int, error) {
@@ -667,84 +620,3 @@ func (g *GoCode) bytePos() (int, error) {
 	}
 	return -1, fmt.Errorf("gocode: invalid offset: %d", g.Pos)
 }
-
-type AstEntry struct {
-	Name    string
-	Body    []byte
-	File    *ast.File
-	FileSet *token.FileSet
-}
-
-type AstCache struct {
-	cache *lru.Cache
-}
-
-func NewAstCache(maxEntries int) *AstCache {
-	return &AstCache{
-		cache: lru.New(maxEntries),
-	}
-}
-
-func (c *AstCache) Add(filename string, ent *AstEntry) {
-	c.cache.Add(filename, ent)
-}
-
-func (c *AstCache) Get(filename string) (*AstEntry, bool) {
-	if v, ok := c.cache.Get(filename); ok {
-		return v.(*AstEntry), true
-	}
-	return nil, false
-}
-
-func (c *AstCache) Remove(filename string) {
-	c.cache.Remove(filename)
-}
-
-func (c *AstCache) ParseFile(filename string, src interface{}) (*token.FileSet, *ast.File, error) {
-	text, err := readSource(filename, src)
-	if err != nil {
-		return nil, nil, err
-	}
-	if e, ok := c.Get(filename); ok {
-		if bytes.Equal(e.Body, text) {
-			return e.FileSet, e.File, nil
-		}
-		c.Remove(filename)
-	}
-	fset := token.NewFileSet()
-	af, err := parser.ParseFile(fset, filename, text, 0)
-	if af == nil {
-		return nil, nil, err
-	}
-	c.Add(filename, &AstEntry{
-		Name:    filename,
-		Body:    text,
-		File:    af,
-		FileSet: fset,
-	})
-	return fset, af, nil
-}
-
-func readSource(filename string, src interface{}) ([]byte, error) {
-	if src != nil {
-		switch s := src.(type) {
-		case string:
-			return []byte(s), nil
-		case []byte:
-			return s, nil
-		case *bytes.Buffer:
-			// is io.Reader, but src is already available in []byte form
-			if s != nil {
-				return s.Bytes(), nil
-			}
-		case io.Reader:
-			var buf bytes.Buffer
-			if _, err := io.Copy(&buf, s); err != nil {
-				return nil, err
-			}
-			return buf.Bytes(), nil
-		}
-		return nil, errors.New("invalid source")
-	}
-	return ioutil.ReadFile(filename)
-}
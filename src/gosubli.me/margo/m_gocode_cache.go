@@ -0,0 +1,350 @@
+package main
+
+import (
+	"go/build"
+	"go/types"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdempsky/gocode/pkg/cache"
+	"golang.org/x/mod/modfile"
+	"gosubli.me/margo/internal/lru"
+)
+
+// gocodeValidateInterval is the minimum interval between checking if the
+// source files of a cached package changed. This keeps repeated completions
+// from stat'ing every package on each keystroke.
+const gocodeValidateInterval = time.Second
+
+// gocodeImporter is a long-lived completion importer for a build context. It
+// remembers the packages it imported and the files they were built from and
+// invalidates them when the sources or export data of the package, or any
+// mutable package it imports, change. Packages in GOROOT and the module cache
+// are never invalidated, but the whole cache is reset if the go.mod or go.sum
+// of the module change.
+type gocodeImporter struct {
+	ctxt    *build.Context
+	root    string // module root, if any
+	logf    func(string, ...interface{})
+	created time.Time
+
+	mu         sync.Mutex
+	imp        types.ImporterFrom
+	modPath    string
+	replace    map[string]string // local replacements: module path => dir
+	modStamp   string
+	modChecked time.Time
+	pkgs       map[gocodeImportKey]*gocodeImportEntry
+
+	hits          int
+	misses        int
+	invalidations int
+}
+
+type gocodeImportKey struct {
+	path, dir string
+}
+
+type gocodeImportEntry struct {
+	pkg     *types.Package
+	stamps  map[string]string // dir or export data => stamp
+	checked time.Time
+}
+
+func newGocodeImporter(ctxt *build.Context, root string, logf func(string, ...interface{})) *gocodeImporter {
+	imp := &gocodeImporter{
+		ctxt:    ctxt,
+		root:    root,
+		logf:    logf,
+		created: time.Now(),
+	}
+	imp.reset()
+	imp.loadModule()
+	return imp
+}
+
+// reset discards all imported packages.
+func (imp *gocodeImporter) reset() {
+	imp.imp = cache.NewIImporter(imp.ctxt, imp.logf)
+	imp.pkgs = make(map[gocodeImportKey]*gocodeImportEntry)
+}
+
+// loadModule reads the module path and local replacements from the go.mod.
+func (imp *gocodeImporter) loadModule() {
+	imp.modPath = ""
+	imp.replace = nil
+	imp.modStamp = imp.moduleStamp()
+	imp.modChecked = time.Now()
+	if imp.root == "" {
+		return
+	}
+	name := filepath.Join(imp.root, "go.mod")
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	f, err := modfile.Parse(name, data, nil)
+	if err != nil {
+		return
+	}
+	if f.Module != nil {
+		imp.modPath = f.Module.Mod.Path
+	}
+	for _, r := range f.Replace {
+		if modfile.IsDirectoryPath(r.New.Path) {
+			dir := r.New.Path
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(imp.root, dir)
+			}
+			if imp.replace == nil {
+				imp.replace = make(map[string]string)
+			}
+			imp.replace[r.Old.Path] = filepath.Clean(dir)
+		}
+	}
+}
+
+func (imp *gocodeImporter) moduleStamp() string {
+	if imp.root == "" {
+		return ""
+	}
+	return fileStamp(filepath.Join(imp.root, "go.mod")) + "|" +
+		fileStamp(filepath.Join(imp.root, "go.sum"))
+}
+
+// fileStamp returns a string that changes when file name is modified.
+func fileStamp(name string) string {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(fi.Size(), 10) + ":" + strconv.FormatInt(fi.ModTime().UnixNano(), 10)
+}
+
+// dirStamp returns a string that changes when a Go source file in dir is
+// added, removed or modified.
+func dirStamp(dir string) string {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	h := fnv.New64a()
+	for _, de := range des {
+		name := de.Name()
+		if de.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		h.Write([]byte(name))
+		h.Write([]byte(strconv.FormatInt(fi.Size(), 10)))
+		h.Write([]byte(strconv.FormatInt(fi.ModTime().UnixNano(), 10)))
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+func pathStamp(name string) string {
+	if strings.HasSuffix(name, ".a") {
+		return fileStamp(name)
+	}
+	return dirStamp(name)
+}
+
+// mutablePaths returns the directory and export data of package path that
+// may change: packages of the main module, local replacements and GOPATH.
+func (imp *gocodeImporter) mutablePaths(path string) []string {
+	match := func(mod string) (string, bool) {
+		if path == mod {
+			return "", true
+		}
+		if strings.HasPrefix(path, mod+"/") {
+			return filepath.FromSlash(path[len(mod)+1:]), true
+		}
+		return "", false
+	}
+	if imp.modPath != "" {
+		if rel, ok := match(imp.modPath); ok {
+			return []string{filepath.Join(imp.root, rel)}
+		}
+	}
+	for mod, dir := range imp.replace {
+		if rel, ok := match(mod); ok {
+			return []string{filepath.Join(dir, rel)}
+		}
+	}
+	if imp.root != "" || isDir(filepath.Join(imp.ctxt.GOROOT, "src", path)) {
+		return nil
+	}
+	pkgdir := imp.ctxt.GOOS + "_" + imp.ctxt.GOARCH
+	if imp.ctxt.InstallSuffix != "" {
+		pkgdir += "_" + imp.ctxt.InstallSuffix
+	}
+	for _, gopath := range filepath.SplitList(imp.ctxt.GOPATH) {
+		dir := filepath.Join(gopath, "src", filepath.FromSlash(path))
+		if isDir(dir) {
+			return []string{
+				dir,
+				filepath.Join(gopath, "pkg", pkgdir, filepath.FromSlash(path)+".a"),
+			}
+		}
+	}
+	return nil
+}
+
+func (imp *gocodeImporter) newEntry(pkg *types.Package, now time.Time) *gocodeImportEntry {
+	e := &gocodeImportEntry{
+		pkg:     pkg,
+		stamps:  make(map[string]string),
+		checked: now,
+	}
+	seen := make(map[*types.Package]bool)
+	var walk func(p *types.Package)
+	walk = func(p *types.Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		for _, name := range imp.mutablePaths(p.Path()) {
+			e.stamps[name] = pathStamp(name)
+		}
+		for _, dep := range p.Imports() {
+			walk(dep)
+		}
+	}
+	walk(pkg)
+	return e
+}
+
+// valid reports if the sources of the package and its dependencies are
+// unchanged.
+func (e *gocodeImportEntry) valid(now time.Time) bool {
+	if now.Sub(e.checked) < gocodeValidateInterval {
+		return true
+	}
+	for name, stamp := range e.stamps {
+		if pathStamp(name) != stamp {
+			return false
+		}
+	}
+	e.checked = now
+	return true
+}
+
+func (imp *gocodeImporter) Import(path string) (*types.Package, error) {
+	return imp.ImportFrom(path, "", 0)
+}
+
+func (imp *gocodeImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(imp.modChecked) >= gocodeValidateInterval {
+		imp.modChecked = now
+		if imp.moduleStamp() != imp.modStamp {
+			imp.invalidations++
+			imp.reset()
+			imp.loadModule()
+		}
+	}
+	key := gocodeImportKey{path, dir}
+	if e := imp.pkgs[key]; e != nil {
+		if e.valid(now) {
+			imp.hits++
+			return e.pkg, nil
+		}
+		// The underlying importer caches the dependencies of the package
+		// as well so start over.
+		imp.invalidations++
+		imp.reset()
+	}
+	imp.misses++
+	pkg, err := imp.imp.ImportFrom(path, dir, mode)
+	if err != nil {
+		return nil, err
+	}
+	imp.pkgs[key] = imp.newEntry(pkg, now)
+	return pkg, nil
+}
+
+// GoCodeCacheRequest lists the completion importers and the packages they
+// cache, or clears them if Clear is set.
+type GoCodeCacheRequest struct {
+	Clear bool `json:"clear"`
+}
+
+type GoCodeCacheEntry struct {
+	GOOS          string    `json:"goos"`
+	GOARCH        string    `json:"goarch"`
+	Tags          []string  `json:"tags,omitempty"`
+	InstallSuffix string    `json:"install_suffix,omitempty"`
+	CgoEnabled    bool      `json:"cgo_enabled"`
+	ModuleRoot    string    `json:"module_root,omitempty"`
+	ModulePath    string    `json:"module_path,omitempty"`
+	Packages      []string  `json:"packages"`
+	Hits          int       `json:"hits"`
+	Misses        int       `json:"misses"`
+	Invalidations int       `json:"invalidations"`
+	Created       time.Time `json:"created"`
+}
+
+type GoCodeCacheResponse struct {
+	Importers []GoCodeCacheEntry `json:"importers"`
+	Cleared   bool               `json:"cleared,omitempty"`
+}
+
+func (imp *gocodeImporter) entry() GoCodeCacheEntry {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+	e := GoCodeCacheEntry{
+		GOOS:          imp.ctxt.GOOS,
+		GOARCH:        imp.ctxt.GOARCH,
+		Tags:          imp.ctxt.BuildTags,
+		InstallSuffix: imp.ctxt.InstallSuffix,
+		CgoEnabled:    imp.ctxt.CgoEnabled,
+		ModuleRoot:    imp.root,
+		ModulePath:    imp.modPath,
+		Packages:      make([]string, 0, len(imp.pkgs)),
+		Hits:          imp.hits,
+		Misses:        imp.misses,
+		Invalidations: imp.invalidations,
+		Created:       imp.created,
+	}
+	seen := make(map[string]bool, len(imp.pkgs))
+	for k := range imp.pkgs {
+		if !seen[k.path] {
+			seen[k.path] = true
+			e.Packages = append(e.Packages, k.path)
+		}
+	}
+	sort.Strings(e.Packages)
+	return e
+}
+
+func (r *GoCodeCacheRequest) Call() (interface{}, string) {
+	res := &GoCodeCacheResponse{Importers: []GoCodeCacheEntry{}}
+	gocodeImporters.Range(func(_ lru.Key, v interface{}) bool {
+		res.Importers = append(res.Importers, v.(*gocodeImporter).entry())
+		return true
+	})
+	if r.Clear {
+		gocodeImporters.Clear()
+		res.Cleared = true
+	}
+	return res, ""
+}
+
+func init() {
+	registry.Register("gocode_cache", func(_ *Broker) Caller {
+		return &GoCodeCacheRequest{}
+	})
+}
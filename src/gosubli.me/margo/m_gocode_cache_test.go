@@ -0,0 +1,83 @@
+package main
+
+import (
+	"go/build"
+	"go/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGocodeImporterCache(t *testing.T) {
+	root := t.TempDir()
+	writeFile := func(name, data string) {
+		t.Helper()
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("go.mod", "module example.com/m\n\nreplace example.com/r => ./r\n")
+	writeFile("a/a.go", "package a\n")
+
+	ctxt := build.Default
+	imp := newGocodeImporter(&ctxt, root, noopLogger)
+
+	if p := imp.mutablePaths("example.com/m/a"); len(p) != 1 || p[0] != filepath.Join(root, "a") {
+		t.Errorf("mutablePaths(example.com/m/a) = %q; want: [%q]", p, filepath.Join(root, "a"))
+	}
+	if p := imp.mutablePaths("example.com/r/x"); len(p) != 1 || p[0] != filepath.Join(root, "r", "x") {
+		t.Errorf("mutablePaths(example.com/r/x) = %q; want: [%q]", p, filepath.Join(root, "r", "x"))
+	}
+	if p := imp.mutablePaths("strings"); len(p) != 0 {
+		t.Errorf("mutablePaths(strings) = %q; want: []", p)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := imp.ImportFrom("strings", root, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if imp.hits != 1 || imp.misses != 1 {
+		t.Errorf("hits = %d misses = %d; want: 1 1", imp.hits, imp.misses)
+	}
+
+	// changing the source of a package invalidates it
+	e := imp.newEntry(types.NewPackage("example.com/m/a", "a"), time.Time{})
+	if !e.valid(time.Now()) {
+		t.Error("entry should be valid")
+	}
+	e.checked = time.Time{}
+	writeFile("a/a.go", "package a\n\nconst A = 1\n")
+	if e.valid(time.Now()) {
+		t.Error("entry should be invalid after the source changed")
+	}
+
+	// changing the go.mod resets the importer
+	writeFile("go.mod", "module example.com/m2\n")
+	imp.modChecked = time.Time{}
+	if _, err := imp.ImportFrom("strings", root, 0); err != nil {
+		t.Fatal(err)
+	}
+	if imp.invalidations != 1 || imp.misses != 2 || imp.modPath != "example.com/m2" {
+		t.Errorf("invalidations = %d misses = %d modPath = %q; want: 1 2 example.com/m2",
+			imp.invalidations, imp.misses, imp.modPath)
+	}
+
+	gocodeImporters.Clear()
+	gocodeImporters.Add(importerKey(&ctxt, root), imp)
+	v, _ := (&GoCodeCacheRequest{}).Call()
+	res := v.(*GoCodeCacheResponse)
+	if len(res.Importers) != 1 || res.Importers[0].ModuleRoot != root ||
+		len(res.Importers[0].Packages) != 1 || res.Importers[0].Packages[0] != "strings" {
+		t.Errorf("unexpected importers: %+v", res.Importers)
+	}
+	(&GoCodeCacheRequest{Clear: true}).Call()
+	if n := gocodeImporters.Len(); n != 0 {
+		t.Errorf("expected 0 cached importers after clear got: %d", n)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charlievieth/buildutil"
+	"github.com/mdempsky/gocode/pkg/suggest"
+)
+
+// Completion contexts that gocode does not handle: import paths, struct tags,
+// build constraints and compiler directives.
+
+// goDirectives are the "//go:" directives offered for completion with their
+// snippets.
+var goDirectives = []struct {
+	name, snippet string
+}{
+	{"build", "build ${1:constraint}"},
+	{"embed", "embed ${1:pattern}"},
+	{"generate", "generate ${1:command}"},
+	{"linkname", "linkname ${1:localname} ${2:importpath.name}"},
+	{"noinline", "noinline"},
+}
+
+// structTagKeys are the struct tag keys offered for completion and the
+// options of each.
+var structTagKeys = []struct {
+	key     string
+	options []string
+}{
+	{"json", []string{"omitempty", "omitzero", "string"}},
+	{"yaml", []string{"omitempty", "flow", "inline"}},
+	{"xml", []string{"omitempty", "attr", "chardata", "innerxml", "comment", "any"}},
+	{"db", nil},
+}
+
+var (
+	directiveRe = regexp.MustCompile(`^\s*//go:([a-z]*)$`)
+	buildLineRe = regexp.MustCompile(`^\s*//go:build\s`)
+)
+
+// splitWords splits identifier name into words at case changes, keeping
+// acronyms together, e.g. "HTTPServerID" => ["HTTP", "Server", "ID"].
+func splitWords(name string) []string {
+	var words []string
+	rs := []rune(name)
+	start := 0
+	for i := 1; i <= len(rs); i++ {
+		if i < len(rs) && rs[i] != '_' && rs[i-1] != '_' {
+			prev, r := rs[i-1], rs[i]
+			upper := unicode.IsUpper(r)
+			switch {
+			case upper && !unicode.IsUpper(prev):
+			case upper && i+1 < len(rs) && unicode.IsLower(rs[i+1]):
+			default:
+				continue
+			}
+		}
+		if w := strings.Trim(string(rs[start:i]), "_"); w != "" {
+			words = append(words, w)
+		}
+		start = i
+	}
+	return words
+}
+
+// snakeCase converts identifier name to snake case, e.g. "UserID" =>
+// "user_id".
+func snakeCase(name string) string {
+	return strings.ToLower(strings.Join(splitWords(name), "_"))
+}
+
+// camelCase converts identifier name to lower camel case, e.g. "UserID" =>
+// "userID".
+func camelCase(name string) string {
+	words := splitWords(name)
+	if len(words) == 0 {
+		return ""
+	}
+	words[0] = strings.ToLower(words[0])
+	for i := 1; i < len(words); i++ {
+		if w := words[i]; strings.ToLower(w) == w {
+			r, size := utf8.DecodeRuneInString(w)
+			words[i] = string(unicode.ToUpper(r)) + w[size:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// wordBefore returns the word, which is made of identifier runes and extra,
+// that ends at the end of s.
+func wordBefore(s, extra string) string {
+	i := len(s)
+	for i > 0 {
+		r, size := utf8.DecodeLastRuneInString(s[:i])
+		if !isIdentRune(r) && !strings.ContainsRune(extra, r) {
+			break
+		}
+		i -= size
+	}
+	return s[i:]
+}
+
+// candidateSet collects candidates that start with prefix, ignoring case.
+type candidateSet struct {
+	prefix string
+	plain  bool
+	cl     []GoCodeCandidate
+}
+
+func (c *candidateSet) add(class, name, typ, snippet string) {
+	if !strings.HasPrefix(strings.ToLower(name), strings.ToLower(c.prefix)) {
+		return
+	}
+	for _, x := range c.cl {
+		if x.Name == name && x.Type == typ {
+			return
+		}
+	}
+	text := snippet
+	if c.plain || text == "" {
+		text = name
+	}
+	c.cl = append(c.cl, GoCodeCandidate{
+		Candidate:  suggest.Candidate{Class: class, Name: name, Type: typ},
+		InsertText: text,
+	})
+}
+
+// directiveCandidates returns the "//go:" directives.
+func (g *GoCode) directiveCandidates(prefix string) []GoCodeCandidate {
+	c := &candidateSet{prefix: prefix, plain: g.PlainText}
+	for _, d := range goDirectives {
+		c.add("directive", d.name, "//go:"+d.name, d.snippet)
+	}
+	return c.cl
+}
+
+// buildLineCandidates returns the GOOS, GOARCH and build tags that can be
+// used in a "//go:build" line.
+func (g *GoCode) buildLineCandidates(ctxt *build.Context, prefix string) []GoCodeCandidate {
+	c := &candidateSet{prefix: prefix, plain: true}
+	c.add("tag", ctxt.GOOS, "GOOS", "")
+	c.add("tag", ctxt.GOARCH, "GOARCH", "")
+	for _, tag := range ctxt.BuildTags {
+		c.add("tag", tag, "tag", "")
+	}
+	for _, s := range buildutil.KnownOSList() {
+		c.add("tag", s, "GOOS", "")
+	}
+	for _, s := range buildutil.KnownArchList() {
+		c.add("tag", s, "GOARCH", "")
+	}
+	for _, tag := range []string{"cgo", "unix", "gc", "gccgo", "ignore"} {
+		c.add("tag", tag, "tag", "")
+	}
+	for i := len(ctxt.ReleaseTags) - 1; i >= 0; i-- {
+		c.add("tag", ctxt.ReleaseTags[i], "release", "")
+	}
+	return c.cl
+}
+
+// tagValueStart returns the key of the struct tag value containing the end of
+// tag and the offset of the value, or false if the end of tag is not in a
+// value.
+func tagValueStart(tag string) (string, int, bool) {
+	for i := 0; i < len(tag); {
+		// skip leading space
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		j := i
+		for j < len(tag) && tag[j] > ' ' && tag[j] != ':' && tag[j] != '"' {
+			j++
+		}
+		if j+1 >= len(tag) || tag[j] != ':' || tag[j+1] != '"' {
+			return "", 0, false
+		}
+		key := tag[i:j]
+		i = j + 2
+		for ; i < len(tag); i++ {
+			if tag[i] == '\\' {
+				i++
+				continue
+			}
+			if tag[i] == '"' {
+				break
+			}
+		}
+		if i >= len(tag) {
+			return key, j + 2, true
+		}
+		i++
+	}
+	return "", 0, false
+}
+
+// structTagCandidates returns the candidates for the struct tag containing
+// cursor: tag keys with the field name, or the names and options of the value
+// of a key.
+func (g *GoCode) structTagCandidates(src []byte, cursor int) ([]GoCodeCandidate, bool) {
+	fset := token.NewFileSet()
+	af, _ := parser.ParseFile(fset, g.filepath(), src, parser.SkipObjectResolution)
+	if af == nil {
+		return nil, false
+	}
+	tf := fset.File(af.Pos())
+	if cursor > tf.Size() {
+		return nil, false
+	}
+	pos := tf.Pos(cursor)
+	var field *ast.Field
+	ast.Inspect(af, func(n ast.Node) bool {
+		if n == nil || field != nil || n.Pos() > pos || n.End() < pos {
+			return false
+		}
+		if f, ok := n.(*ast.Field); ok && f.Tag != nil && f.Tag.Pos() < pos && pos < f.Tag.End() {
+			field = f
+		}
+		return true
+	})
+	if field == nil || !strings.HasPrefix(field.Tag.Value, "`") {
+		return nil, false
+	}
+
+	var name string
+	switch {
+	case len(field.Names) != 0:
+		name = field.Names[0].Name
+	default:
+		// embedded field
+		typ := field.Type
+		if st, ok := typ.(*ast.StarExpr); ok {
+			typ = st.X
+		}
+		if sel, ok := typ.(*ast.SelectorExpr); ok {
+			typ = sel.Sel
+		}
+		if id, ok := typ.(*ast.Ident); ok {
+			name = id.Name
+		}
+	}
+	names := []string{camelCase(name), snakeCase(name)}
+
+	tag := string(src[tf.Offset(field.Tag.Pos())+1 : cursor])
+	key, off, inValue := tagValueStart(tag)
+	if !inValue {
+		c := &candidateSet{prefix: wordBefore(tag, "")}
+		for _, k := range structTagKeys {
+			for _, s := range names {
+				if s == "" {
+					continue
+				}
+				value := k.key + `:"` + s + `"`
+				snippet := k.key + `:"${1:` + snippetEscaper.Replace(s) + `}"`
+				if g.PlainText {
+					snippet = value
+				}
+				c.add("tag", k.key, value, snippet)
+			}
+		}
+		return c.cl, true
+	}
+
+	value := tag[off:]
+	c := &candidateSet{prefix: wordBefore(value, "-"), plain: true}
+	if !strings.Contains(value, ",") {
+		for _, s := range names {
+			if s != "" {
+				c.add("tag", s, key, "")
+			}
+		}
+		c.add("tag", "-", key, "")
+		return c.cl, true
+	}
+	for _, k := range structTagKeys {
+		if k.key == key {
+			for _, opt := range k.options {
+				c.add("tag", opt, key+" option", "")
+			}
+		}
+	}
+	return c.cl, true
+}
+
+// contextCandidates returns the candidates for completion contexts that
+// gocode does not handle, if the cursor is in one.
+func (g *GoCode) contextCandidates(ctxt *build.Context) ([]GoCodeCandidate, bool) {
+	cursor, err := g.bytePos()
+	if err != nil {
+		return nil, false
+	}
+	line := g.Src[strings.LastIndexByte(g.Src[:cursor], '\n')+1 : cursor]
+	if m := directiveRe.FindStringSubmatch(line); m != nil {
+		return g.directiveCandidates(m[1]), true
+	}
+	if buildLineRe.MatchString(line) {
+		return g.buildLineCandidates(ctxt, wordBefore(line, ".")), true
+	}
+	if strings.ContainsAny(line, "\"`") {
+		if prefix, ok := importPathAt(g.filepath(), []byte(g.Src), cursor); ok {
+			return g.importPathCandidates(prefix), true
+		}
+	}
+	// struct tags are raw strings
+	if strings.Contains(line, "`") {
+		return g.structTagCandidates([]byte(g.Src), cursor)
+	}
+	return nil, false
+}
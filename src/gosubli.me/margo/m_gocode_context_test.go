@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in           string
+		snake, camel string
+	}{
+		{"Name", "name", "name"},
+		{"UserID", "user_id", "userID"},
+		{"HTTPServerAddr", "http_server_addr", "httpServerAddr"},
+		{"createdAt", "created_at", "createdAt"},
+		{"max_size", "max_size", "maxSize"},
+		{"ID", "id", "id"},
+	}
+	for _, x := range tests {
+		if s := snakeCase(x.in); s != x.snake {
+			t.Errorf("snakeCase(%q) = %q; want: %q", x.in, s, x.snake)
+		}
+		if s := camelCase(x.in); s != x.camel {
+			t.Errorf("camelCase(%q) = %q; want: %q", x.in, s, x.camel)
+		}
+	}
+}
+
+func TestContextCandidates(t *testing.T) {
+	complete := func(src string) ([]GoCodeCandidate, bool) {
+		cursor := strings.Index(src, "$")
+		src = src[:cursor] + src[cursor+1:]
+		g := &GoCode{Fn: "/tmp/p/p.go", Src: src, Pos: len([]rune(src[:cursor]))}
+		return g.contextCandidates(g.buildContext())
+	}
+	names := func(cl []GoCodeCandidate) []string {
+		a := make([]string, len(cl))
+		for i, c := range cl {
+			a[i] = c.Name
+		}
+		return a
+	}
+
+	cl, ok := complete("package p\n\ntype T struct {\n\tUserID int `j$`\n}\n")
+	if !ok || len(cl) != 2 {
+		t.Fatalf("key: got: %+v", cl)
+	}
+	if cl[0].Type != `json:"userID"` || cl[0].InsertText != `json:"${1:userID}"` ||
+		cl[1].Type != `json:"user_id"` {
+		t.Errorf("key: got: %+v", cl)
+	}
+
+	cl, _ = complete("package p\n\ntype T struct {\n\tUserID int `json:\"$\"`\n}\n")
+	if want := []string{"userID", "user_id", "-"}; !reflect.DeepEqual(names(cl), want) {
+		t.Errorf("value: got: %q want: %q", names(cl), want)
+	}
+
+	cl, _ = complete("package p\n\ntype T struct {\n\tUserID int `json:\"id\" yaml:\"id,$\"`\n}\n")
+	if want := []string{"omitempty", "flow", "inline"}; !reflect.DeepEqual(names(cl), want) {
+		t.Errorf("option: got: %q want: %q", names(cl), want)
+	}
+
+	cl, _ = complete("//go:build linux && !wind$\n\npackage p\n")
+	if want := []string{"windows"}; !reflect.DeepEqual(names(cl), want) {
+		t.Errorf("build: got: %q want: %q", names(cl), want)
+	}
+
+	cl, _ = complete("package p\n\n//go:n$\nfunc f() {}\n")
+	if len(cl) != 1 || cl[0].Name != "noinline" {
+		t.Errorf("directive: got: %+v", cl)
+	}
+	cl, _ = complete("package p\n\n//go:$\n")
+	if want := []string{"build", "embed", "generate", "linkname", "noinline"}; !reflect.DeepEqual(names(cl), want) {
+		t.Errorf("directive: got: %q want: %q", names(cl), want)
+	}
+
+	if _, ok := complete("package p\n\nvar s = `abc$`\n"); ok {
+		t.Error("raw string: expected no context candidates")
+	}
+	if _, ok := complete("package p\n\nfunc f() { fmt.$ }\n"); ok {
+		t.Error("selector: expected no context candidates")
+	}
+}
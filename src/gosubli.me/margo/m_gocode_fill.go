@@ -0,0 +1,155 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/mdempsky/gocode/pkg/suggest"
+)
+
+// compositeLitAt returns the innermost composite literal of af whose braces
+// contain pos, if pos is not inside one of its elements and its elements are
+// keyed.
+func compositeLitAt(af *ast.File, pos token.Pos) *ast.CompositeLit {
+	var lit *ast.CompositeLit
+	ast.Inspect(af, func(n ast.Node) bool {
+		if n == nil || n.Pos() > pos || n.End() < pos {
+			return false
+		}
+		if c, ok := n.(*ast.CompositeLit); ok && c.Lbrace < pos && pos <= c.Rbrace {
+			lit = c
+		}
+		return true
+	})
+	if lit == nil {
+		return nil
+	}
+	for _, elt := range lit.Elts {
+		if elt.Pos() <= pos && pos < elt.End() {
+			return nil
+		}
+		if _, ok := elt.(*ast.KeyValueExpr); !ok {
+			return nil // positional fields
+		}
+	}
+	return lit
+}
+
+// hasCompositeLitAt reports if compositeLitAt finds a literal at cursor in
+// the parsed source of the file.
+func hasCompositeLitAt(filename, src string, cursor int) bool {
+	fset := token.NewFileSet()
+	af, _ := parser.ParseFile(fset, filename, src, parser.SkipObjectResolution)
+	if af == nil {
+		return false
+	}
+	tf := fset.File(af.Pos())
+	return cursor <= tf.Size() && compositeLitAt(af, tf.Pos(cursor)) != nil
+}
+
+// lineIndent returns the leading whitespace of the line containing offset off
+// of src.
+func lineIndent(src string, off int) string {
+	start := strings.LastIndexByte(src[:off], '\n') + 1
+	end := start
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	return src[start:end]
+}
+
+// fillStructCandidates returns a candidate that inserts the unset fields of
+// the struct literal containing the cursor as "Field: <zero value>,". The
+// unexported fields are only inserted if the struct is declared in the
+// package being edited.
+func (g *GoCode) fillStructCandidates(ctxt *build.Context) []GoCodeCandidate {
+	cursor, err := g.bytePos()
+	if err != nil {
+		return nil
+	}
+	// only offered at the start of an element
+	before := strings.TrimRight(g.Src[:cursor], " \t\r\n")
+	if !strings.HasSuffix(before, "{") && !strings.HasSuffix(before, ",") {
+		return nil
+	}
+	// parse the file before type checking the package to rule out blocks,
+	// calls and positional literals
+	filename := g.filepath()
+	if !hasCompositeLitAt(filename, g.Src, cursor) {
+		return nil
+	}
+
+	fset, af, pkg, info, _ := checkPackageFile(ctxt, g.importer(ctxt), filename, []byte(g.Src))
+	if af == nil {
+		return nil
+	}
+	tf := fset.File(af.Pos())
+	if cursor > tf.Size() {
+		return nil
+	}
+	lit := compositeLitAt(af, tf.Pos(cursor))
+	if lit == nil {
+		return nil
+	}
+	typ := info.Types[lit].Type
+	if typ == nil {
+		return nil
+	}
+	if p, ok := typ.Underlying().(*types.Pointer); ok {
+		typ = p.Elem()
+	}
+	st, ok := typ.Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+
+	set := make(map[string]bool, len(lit.Elts))
+	for _, elt := range lit.Elts {
+		if id, ok := elt.(*ast.KeyValueExpr).Key.(*ast.Ident); ok {
+			set[id.Name] = true
+		}
+	}
+	qf := packageQualifier(pkg)
+	var lines []string
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if set[f.Name()] || f.Name() == "_" || (!f.Exported() && f.Pkg() != pkg) {
+			continue
+		}
+		zero := zeroValue(f.Type(), qf)
+		if !g.PlainText {
+			zero = "${" + strconv.Itoa(len(lines)+1) + ":" + snippetEscaper.Replace(zero) + "}"
+		}
+		lines = append(lines, f.Name()+": "+zero+",")
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	// indent the fields one level deeper than the line of the opening brace
+	// or as the cursor if it is on a line of its own
+	braceOff := tf.Offset(lit.Lbrace)
+	indent := lineIndent(g.Src, braceOff)
+	lineStart := strings.LastIndexByte(g.Src[:cursor], '\n') + 1
+	var text string
+	switch {
+	case lineStart > braceOff && strings.TrimLeft(g.Src[lineStart:cursor], " \t") == "":
+		text = strings.Join(lines, "\n"+g.Src[lineStart:cursor])
+	case len(lit.Elts) == 0:
+		text = "\n" + indent + "\t" + strings.Join(lines, "\n"+indent+"\t") + "\n" + indent
+	default:
+		text = strings.Join(lines, "\n"+indent+"\t")
+	}
+	return []GoCodeCandidate{{
+		Candidate: suggest.Candidate{
+			Class: "fill",
+			Name:  "fill",
+			Type:  types.TypeString(typ, qf),
+		},
+		InsertText: text,
+	}}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFillStructCandidates(t *testing.T) {
+	dir := t.TempDir()
+	const src = `package p
+
+import (
+	"bytes"
+	"image"
+)
+
+type Config struct {
+	Name    string
+	Retries int
+	Verbose bool
+	Point   image.Point
+	Tags    []string
+	timeout int
+}
+
+var _ = bytes.MinRead
+
+func f() {
+	_ = $
+}
+`
+	complete := func(expr string, plain bool) []GoCodeCandidate {
+		t.Helper()
+		s := strings.Replace(src, "$", expr, 1)
+		cursor := strings.Index(s, "|")
+		s = s[:cursor] + s[cursor+1:]
+		g := &GoCode{Fn: filepath.Join(dir, "p.go"), Src: s, Pos: cursor, PlainText: plain}
+		return g.fillStructCandidates(g.buildContext())
+	}
+
+	cl := complete("Config{|}", false)
+	want := "\n\t\tName: ${1:\"\"},\n\t\tRetries: ${2:0},\n\t\tVerbose: ${3:false},\n\t\tPoint: ${4:image.Point{\\}},\n" +
+		"\t\tTags: ${5:nil},\n\t\ttimeout: ${6:0},\n\t"
+	if len(cl) != 1 || cl[0].InsertText != want || cl[0].Type != "Config" {
+		t.Errorf("Config: got: %+v\nwant: %q", cl, want)
+	}
+
+	// set fields are skipped
+	cl = complete("&Config{\n\t\tName: \"a\",\n\t\t|\n\t}", true)
+	want = "Retries: 0,\n\t\tVerbose: false,\n\t\tPoint: image.Point{},\n\t\tTags: nil,\n\t\ttimeout: 0,"
+	if len(cl) != 1 || cl[0].InsertText != want {
+		t.Errorf("&Config: got: %+v\nwant: %q", cl, want)
+	}
+	cl = complete("&Config{Name: \"a\", |}", true)
+	want = "Retries: 0,\n\t\tVerbose: false,\n\t\tPoint: image.Point{},\n\t\tTags: nil,\n\t\ttimeout: 0,"
+	if len(cl) != 1 || cl[0].InsertText != want {
+		t.Errorf("&Config{Name: \"a\", |}: got: %+v\nwant: %q", cl, want)
+	}
+
+	// unexported fields of other packages are not set
+	cl = complete("image.Point{|}", true)
+	if len(cl) != 1 || cl[0].InsertText != "\n\t\tX: 0,\n\t\tY: 0,\n\t" {
+		t.Errorf("image.Point: got: %+v", cl)
+	}
+	if cl := complete("bytes.Buffer{|}", true); len(cl) != 0 {
+		t.Errorf("bytes.Buffer: expected no candidates got: %+v", cl)
+	}
+
+	for _, expr := range []string{
+		"Config{Name: |}",
+		"Config{N|}",
+		"image.Point{1, |}",
+		"[]int{|}",
+		"f(|)",
+	} {
+		if cl := complete(expr, true); len(cl) != 0 {
+			t.Errorf("%s: expected no candidates got: %+v", expr, cl)
+		}
+	}
+}
+
+func TestHasCompositeLitAt(t *testing.T) {
+	for src, want := range map[string]bool{
+		"package p\n\nvar _ = T{|}\n":         true,
+		"package p\n\nvar _ = T{A: 1, |}\n":   true,
+		"package p\n\nvar _ = T{1, |}\n":      false,
+		"package p\n\nfunc f() {|}\n":         false,
+		"package p\n\nfunc f() { g(1, |) }\n": false,
+	} {
+		cursor := strings.Index(src, "|")
+		src = src[:cursor] + src[cursor+1:]
+		if got := hasCompositeLitAt("p.go", src, cursor); got != want {
+			t.Errorf("%q: got: %t want: %t", src, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mdempsky/gocode/pkg/suggest"
+	"go.uber.org/zap"
+)
+
+// maxImportPathCandidates is the maximum number of import path candidates.
+const maxImportPathCandidates = 50
+
+// importPathAt returns the text between the opening quote of the import path
+// containing cursor and the cursor.
+func importPathAt(filename string, src []byte, cursor int) (string, bool) {
+	fset := token.NewFileSet()
+	af, _ := parser.ParseFile(fset, filename, src, parser.ImportsOnly|parser.SkipObjectResolution)
+	if af == nil {
+		return "", false
+	}
+	tf := fset.File(af.Pos())
+	if cursor > tf.Size() {
+		return "", false
+	}
+	pos := tf.Pos(cursor)
+	for _, spec := range af.Imports {
+		lit := spec.Path
+		if lit == nil || len(lit.Value) == 0 || lit.Pos() >= pos {
+			continue
+		}
+		// the literal of an unterminated import path ends at the cursor
+		quote := lit.Value[0]
+		closed := len(lit.Value) > 1 && lit.Value[len(lit.Value)-1] == quote
+		if pos < lit.End() || (!closed && pos == lit.End()) {
+			prefix := string(src[tf.Offset(lit.Pos())+1 : cursor])
+			if strings.ContainsAny(prefix, "\"`\n") {
+				return "", false
+			}
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// importPathScore returns how well import path matches query, ignoring case:
+// 0 if path starts with query, 1 if an element of path does, 2 if query is a
+// subsequence of path and -1 if it does not match.
+func importPathScore(path, query string) int {
+	path = strings.ToLower(path)
+	query = strings.ToLower(query)
+	switch {
+	case strings.HasPrefix(path, query):
+		return 0
+	case strings.Contains(path, "/"+query):
+		return 1
+	}
+	i := 0
+	for j := 0; j < len(path) && i < len(query); j++ {
+		if path[j] == query[i] {
+			i++
+		}
+	}
+	if i == len(query) {
+		return 2
+	}
+	return -1
+}
+
+// importPathCandidates returns the packages whose import path matches prefix
+// ranked by how well they match and then by kind (see knownPackages). The
+// insert text of a candidate is its full import path, which replaces the text
+// between the opening quote and the cursor. The doc of a candidate is the
+// synopsis listed with its package, if any, otherwise it is left to
+// completion_resolve.
+func (g *GoCode) importPathCandidates(prefix string) []GoCodeCandidate {
+	dir := filepath.Dir(g.filepath())
+	known, err := knownPackages(g.Env, g.InstallSuffix, dir)
+	if err != nil {
+		logger.Named("gocode").Debug("listing packages", zap.String("filename", g.shortFilename()),
+			zap.Error(err))
+	}
+	type match struct {
+		pkg   *importPackage
+		score int
+	}
+	var matches []match
+	for i := range known {
+		p := &known[i]
+		if p.Dir != "" && p.Dir == dir {
+			continue // the package being edited
+		}
+		if score := importPathScore(p.Path, prefix); score != -1 {
+			matches = append(matches, match{p, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score < matches[j].score
+	})
+	if len(matches) > maxImportPathCandidates {
+		matches = matches[:maxImportPathCandidates]
+	}
+
+	cl := make([]GoCodeCandidate, 0, len(matches))
+	for _, m := range matches {
+		p := m.pkg
+		cl = append(cl, GoCodeCandidate{
+			Candidate: suggest.Candidate{
+				Class:   "package",
+				PkgPath: p.Path,
+				Name:    p.Path,
+				Type:    p.Name,
+			},
+			InsertText: p.Path,
+			Doc:        p.Doc,
+		})
+	}
+	return cl
+}
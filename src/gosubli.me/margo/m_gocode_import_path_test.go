@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportPathAt(t *testing.T) {
+	tests := []struct {
+		src    string
+		prefix string
+		ok     bool
+	}{
+		{"package p\n\nimport \"enc$\"\n", "enc", true},
+		{"package p\n\nimport (\n\t\"fmt\"\n\tj \"encoding/j$\"\n)\n", "encoding/j", true},
+		{"package p\n\nimport \"$\"\n", "", true},
+		{"package p\n\nimport \"str$\n", "str", true},
+		{"package p\n\nimport \"fmt\"$\n", "", false},
+		{"package p\n\nvar s = \"fmt$\"\n", "", false},
+	}
+	for _, x := range tests {
+		cursor := strings.Index(x.src, "$")
+		src := x.src[:cursor] + x.src[cursor+1:]
+		prefix, ok := importPathAt("p.go", []byte(src), cursor)
+		if prefix != x.prefix || ok != x.ok {
+			t.Errorf("%q: got: %q %t want: %q %t", x.src, prefix, ok, x.prefix, x.ok)
+		}
+	}
+}
+
+func TestImportPathScore(t *testing.T) {
+	tests := []struct {
+		path, query string
+		score       int
+	}{
+		{"strconv", "str", 0},
+		{"encoding/json", "json", 1},
+		{"encoding/json", "JSON", 1},
+		{"encoding/json", "ejs", 2},
+		{"encoding/json", "xml", -1},
+		{"fmt", "", 0},
+	}
+	for _, x := range tests {
+		if s := importPathScore(x.path, x.query); s != x.score {
+			t.Errorf("importPathScore(%q, %q) = %d; want: %d", x.path, x.query, s, x.score)
+		}
+	}
+}
+
+func TestImportPathCandidates(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a.go":           "package lint\n",
+		"util/util.go":   "// Package util has helpers.\npackage util\n",
+		"strutil/str.go": "package strutil\n",
+	})
+	// module packages are listed in the background
+	if _, err := loadKnownPackages(nil, "", dir); err != nil {
+		t.Fatal(err)
+	}
+	complete := func(src string) []GoCodeCandidate {
+		t.Helper()
+		cursor := strings.Index(src, "$")
+		src = src[:cursor] + src[cursor+1:]
+		g := &GoCode{Fn: filepath.Join(dir, "a.go"), Src: src, Pos: cursor}
+		cl, ok := g.contextCandidates(g.buildContext())
+		if !ok {
+			t.Fatalf("%q: not an import path", src)
+		}
+		return cl
+	}
+
+	cl := complete("package lint\n\nimport \"strc$\"\n")
+	if len(cl) == 0 || cl[0].Name != "strconv" || cl[0].Type != "strconv" ||
+		!strings.HasPrefix(cl[0].Doc, "Package strconv implements conversions") {
+		t.Fatalf("strc: got: %+v", cl)
+	}
+
+	cl = complete("package lint\n\nimport \"util$\"\n")
+	if len(cl) == 0 || cl[0].Name != "example.com/lint/util" || cl[0].Doc != "Package util has helpers." {
+		t.Fatalf("util: got: %+v", cl)
+	}
+
+	// paths that start with the query rank above element matches
+	cl = complete("package lint\n\nimport \"str$\"\n")
+	var paths []string
+	for _, c := range cl {
+		paths = append(paths, c.Name)
+	}
+	i := strings.Index(strings.Join(paths, " "), "strconv")
+	j := strings.Index(strings.Join(paths, " "), "example.com/lint/strutil")
+	if i == -1 || j == -1 || i > j {
+		t.Errorf("str: unexpected ranking: %q", paths)
+	}
+	if len(cl) > maxImportPathCandidates {
+		t.Errorf("str: expected at most %d candidates got: %d", maxImportPathCandidates, len(cl))
+	}
+}
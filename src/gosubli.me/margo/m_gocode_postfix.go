@@ -0,0 +1,271 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/types"
+	"strings"
+
+	"github.com/mdempsky/gocode/pkg/suggest"
+	"go.uber.org/zap"
+)
+
+// postfixTemplate is a completion that replaces the expression it follows,
+// e.g. "err.ifnil" => "if err != nil { return err }".
+type postfixTemplate struct {
+	name string
+	// stmt templates are only offered for expression statements
+	stmt bool
+	// snippet returns the snippet, which replaces the expression and the
+	// template, and the packages it imports, or false if the template does
+	// not apply to the type of the expression.
+	snippet func(p *postfixContext) (string, []string, bool)
+}
+
+// postfixContext is the expression a template is applied to.
+type postfixContext struct {
+	expr string     // expression source
+	typ  types.Type // expression type
+	sig  *types.Signature
+	qf   types.Qualifier
+}
+
+var postfixTemplates = []postfixTemplate{
+	{"ifnil", true, postfixIfNil},
+	{"for", true, postfixFor},
+	{"keys", true, postfixKeys},
+	{"len", false, postfixLen},
+	{"print", true, postfixPrint},
+}
+
+// minPostfixPrefix is the number of characters of a template name that must
+// be typed before templates are offered.
+const minPostfixPrefix = 2
+
+var errorInterface = types.Universe.Lookup("error").Type().Underlying().(*types.Interface)
+
+// zeroValue returns the zero value of type t.
+func zeroValue(t types.Type, qf types.Qualifier) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		case u.Info()&types.IsString != 0:
+			return `""`
+		}
+		return "nil"
+	case *types.Struct, *types.Array:
+		return types.TypeString(t, qf) + "{}"
+	case *types.Interface:
+		if _, ok := t.(*types.TypeParam); ok {
+			return "*new(" + types.TypeString(t, qf) + ")"
+		}
+	}
+	return "nil"
+}
+
+func postfixIfNil(p *postfixContext) (string, []string, bool) {
+	if !types.Implements(p.typ, errorInterface) {
+		return "", nil, false
+	}
+	var results []string
+	if p.sig != nil {
+		for i := 0; i < p.sig.Results().Len(); i++ {
+			t := p.sig.Results().At(i).Type()
+			if types.Identical(t, types.Universe.Lookup("error").Type()) {
+				results = append(results, p.expr)
+			} else {
+				results = append(results, zeroValue(t, p.qf))
+			}
+		}
+	}
+	ret := "return"
+	if len(results) != 0 {
+		ret += " ${1:" + snippetEscaper.Replace(strings.Join(results, ", ")) + "}"
+	}
+	expr := snippetEscaper.Replace(p.expr)
+	return "if " + expr + " != nil {\n\t" + ret + "\n}", nil, true
+}
+
+func postfixFor(p *postfixContext) (string, []string, bool) {
+	expr := snippetEscaper.Replace(p.expr)
+	switch p.typ.Underlying().(type) {
+	case *types.Slice, *types.Array:
+		return "for ${1:i}, ${2:v} := range " + expr + " {\n\t$0\n}", nil, true
+	case *types.Map:
+		return "for ${1:k}, ${2:v} := range " + expr + " {\n\t$0\n}", nil, true
+	case *types.Chan:
+		return "for ${1:v} := range " + expr + " {\n\t$0\n}", nil, true
+	}
+	return "", nil, false
+}
+
+func postfixKeys(p *postfixContext) (string, []string, bool) {
+	m, ok := p.typ.Underlying().(*types.Map)
+	if !ok {
+		return "", nil, false
+	}
+	if b, ok := m.Key().Underlying().(*types.Basic); !ok || b.Info()&types.IsOrdered == 0 {
+		return "", nil, false
+	}
+	expr := snippetEscaper.Replace(p.expr)
+	key := snippetEscaper.Replace(types.TypeString(m.Key(), p.qf))
+	return "keys := make([]" + key + ", 0, len(" + expr + "))\n" +
+		"for k := range " + expr + " {\n\tkeys = append(keys, k)\n}\n" +
+		"sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })\n" +
+		"for _, k := range keys {\n\t${1:" + expr + "[k]}$0\n}", []string{"sort"}, true
+}
+
+func postfixLen(p *postfixContext) (string, []string, bool) {
+	switch u := p.typ.Underlying().(type) {
+	case *types.Slice, *types.Array, *types.Map, *types.Chan:
+	case *types.Basic:
+		if u.Info()&types.IsString == 0 {
+			return "", nil, false
+		}
+	default:
+		return "", nil, false
+	}
+	return "len(" + snippetEscaper.Replace(p.expr) + ")", nil, true
+}
+
+func postfixPrint(p *postfixContext) (string, []string, bool) {
+	return "fmt.Println(" + snippetEscaper.Replace(p.expr) + ")$0", []string{"fmt"}, true
+}
+
+// isPrimaryExpr reports if e is an operand of a selector expression.
+func isPrimaryExpr(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.CallExpr, *ast.IndexExpr, *ast.IndexListExpr,
+		*ast.SliceExpr, *ast.TypeAssertExpr, *ast.ParenExpr, *ast.CompositeLit, *ast.BasicLit:
+		return true
+	}
+	return false
+}
+
+// postfixCandidates returns the postfix templates that apply to the
+// expression before the cursor, e.g. "err.if|". The type of the expression is
+// found by type checking the package with the completion importer, which is
+// costly, so the package is only checked once at least minPostfixPrefix
+// characters of a template name are typed (template names are lower case so
+// exported members do not match) and the operand is not a package name.
+// Templates are snippets so they are not offered if plain text was requested.
+func (g *GoCode) postfixCandidates(ctxt *build.Context) []GoCodeCandidate {
+	cursor, err := g.bytePos()
+	if err != nil || g.PlainText {
+		return nil
+	}
+	src := []byte(g.Src)
+	prefix := wordBefore(g.Src[:cursor], "")
+	dot := cursor - len(prefix) - 1
+	// require part of the template name since the package is type checked
+	if len(prefix) < minPostfixPrefix || dot <= 0 || src[dot] != '.' {
+		return nil
+	}
+	var templates []postfixTemplate
+	for _, t := range postfixTemplates {
+		if strings.HasPrefix(t.name, prefix) {
+			templates = append(templates, t)
+		}
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+	filename := g.filepath()
+	if name, _, off, ok := selectorAt(src, cursor); ok && isPackageName(ctxt, filename, src, name, off) {
+		return nil
+	}
+
+	// remove the template so that the expression is a statement
+	checked := append([]byte(nil), src...)
+	for i := dot; i < cursor; i++ {
+		checked[i] = ' '
+	}
+	fset, af, pkg, info, _ := checkPackageFile(ctxt, g.importer(ctxt), filename, checked)
+	if af == nil {
+		return nil
+	}
+	tf := fset.File(af.Pos())
+	if cursor > tf.Size() {
+		return nil
+	}
+
+	dotPos := tf.Pos(dot)
+	var expr ast.Expr
+	var path, stack []ast.Node
+	ast.Inspect(af, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		if expr != nil || n.Pos() > dotPos || n.End() < dotPos {
+			return false
+		}
+		stack = append(stack, n)
+		if e, ok := n.(ast.Expr); ok && e.End() == dotPos && isPrimaryExpr(e) {
+			if tv, ok := info.Types[e]; ok && tv.IsValue() {
+				expr = e
+				path = append(path, stack...)
+			}
+		}
+		return true
+	})
+	if expr == nil {
+		return nil
+	}
+
+	p := &postfixContext{
+		expr: string(src[tf.Offset(expr.Pos()):dot]),
+		typ:  info.Types[expr].Type,
+		qf:   packageQualifier(pkg),
+	}
+	isStmt := false
+	if len(path) > 1 {
+		_, isStmt = path[len(path)-2].(*ast.ExprStmt)
+	}
+	for i := len(path) - 1; i >= 0 && p.sig == nil; i-- {
+		switch fn := path[i].(type) {
+		case *ast.FuncDecl:
+			if obj, ok := info.Defs[fn.Name].(*types.Func); ok {
+				p.sig = obj.Type().(*types.Signature)
+			}
+		case *ast.FuncLit:
+			p.sig, _ = info.Types[fn].Type.(*types.Signature)
+		}
+	}
+
+	// the expression and dot are removed when the template is inserted
+	remove := newTextEdit(src, tf.Offset(expr.Pos()), dot+1, "")
+	var cl []GoCodeCandidate
+	for _, t := range templates {
+		if t.stmt && !isStmt {
+			continue
+		}
+		snippet, imports, ok := t.snippet(p)
+		if !ok {
+			continue
+		}
+		edits := []TextEdit{remove}
+		for _, ipath := range imports {
+			ie, err := addImportEdits(filename, src, ipath)
+			if err != nil {
+				logger.Named("gocode").Debug("adding import", zap.String("path", ipath), zap.Error(err))
+				continue
+			}
+			edits = append(edits, ie...)
+		}
+		cl = append(cl, GoCodeCandidate{
+			Candidate: suggest.Candidate{
+				Class: "postfix",
+				Name:  t.name,
+				Type:  types.TypeString(p.typ, p.qf),
+			},
+			InsertText:      snippet,
+			AdditionalEdits: edits,
+		})
+	}
+	return cl
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPostfixCandidates(t *testing.T) {
+	dir := t.TempDir()
+	const src = `package p
+
+import "os"
+
+type T struct{}
+
+func f(m map[string]int, s []int, ch chan int, x T) (int, error) {
+	_, err := os.Open("x")
+	$
+	return 0, nil
+}
+`
+	complete := func(stmt string) map[string]GoCodeCandidate {
+		t.Helper()
+		s := strings.Replace(src, "$", stmt, 1)
+		cursor := strings.Index(s, "|")
+		s = s[:cursor] + s[cursor+1:]
+		g := &GoCode{Fn: filepath.Join(dir, "p.go"), Src: s, Pos: cursor}
+		res := make(map[string]GoCodeCandidate)
+		for _, c := range g.postfixCandidates(g.buildContext()) {
+			res[c.Name] = c
+		}
+		return res
+	}
+
+	cl := complete("err.if|")
+	c, ok := cl["ifnil"]
+	if !ok {
+		t.Fatalf("err.if: missing ifnil: %+v", cl)
+	}
+	if want := "if err != nil {\n\treturn ${1:0, err}\n}"; c.InsertText != want {
+		t.Errorf("ifnil: got: %q want: %q", c.InsertText, want)
+	}
+	if len(c.AdditionalEdits) != 1 || c.AdditionalEdits[0].NewText != "" ||
+		c.AdditionalEdits[0].End-c.AdditionalEdits[0].Offset != len("err.") {
+		t.Errorf("ifnil: unexpected edits: %+v", c.AdditionalEdits)
+	}
+
+	for stmt, want := range map[string][]string{
+		"s.|le":      nil, // cursor must follow the template name
+		"s.l|":       nil, // too short
+		"s.le|":      {"len"},
+		"s.for|":     {"for"},
+		"m.|":        nil,
+		"m.ke|":      {"keys"},
+		"ch.fo|":     {"for"},
+		"x.fo|":      nil,
+		"x.le|":      nil,
+		"x.pr|":      {"print"},
+		"x.Pr|":      nil, // template names are case-sensitive
+		"s.Le|":      nil,
+		"err.fo|":    nil,
+		"_ = s.for|": nil,
+		"_ = s.len|": {"len"},
+	} {
+		cl := complete(stmt)
+		if len(cl) != len(want) {
+			t.Errorf("%s: got: %d candidates want: %q", stmt, len(cl), want)
+			continue
+		}
+		for _, name := range want {
+			if _, ok := cl[name]; !ok {
+				t.Errorf("%s: missing: %q", stmt, name)
+			}
+		}
+	}
+
+	c = complete("m.keys|")["keys"]
+	if !strings.Contains(c.InsertText, "keys := make([]string, 0, len(m))") ||
+		!strings.HasSuffix(c.InsertText, "for _, k := range keys {\n\t${1:m[k]}$0\n}") || len(c.AdditionalEdits) < 2 {
+		t.Errorf("keys: got: %+v", c)
+	}
+	if c := complete("x.print|")["print"]; c.InsertText != "fmt.Println(x)$0" || len(c.AdditionalEdits) < 2 {
+		t.Errorf("print: got: %+v", c)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gosubli.me/margo/internal/lru"
+)
+
+// CompletionResolveRequest loads the documentation of completion candidates.
+// Docs are not included in the gocode_complete response to keep it fast and
+// are instead resolved for the candidates the client shows.
+type CompletionResolveRequest struct {
+	Filename   string            `json:"filename"`
+	Src        string            `json:"src,omitempty"`
+	Env        map[string]string `json:"env"`
+	Candidates []GoCodeCandidate `json:"candidates"`
+}
+
+// packageDocs are the doc comments of the declarations of a package.
+type packageDocs struct {
+	stamp string
+	pkg   string            // package doc
+	docs  map[string]string // "Name" or "Type.Member" => doc
+}
+
+// completionDocs caches the docs of a package directory.
+var completionDocs = lru.New(64)
+
+// isDeprecated reports if doc comment text has a paragraph that starts with
+// "Deprecated: ", which is the convention for marking deprecated symbols.
+func isDeprecated(text string) bool {
+	for _, para := range strings.Split(text, "\n\n") {
+		if strings.HasPrefix(strings.TrimSpace(para), "Deprecated: ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *packageDocs) add(name string, groups ...*ast.CommentGroup) {
+	for _, g := range groups {
+		if text := g.Text(); text != "" {
+			p.docs[name] = text
+			return
+		}
+	}
+}
+
+func (p *packageDocs) addFields(typ string, fields *ast.FieldList) {
+	if fields == nil {
+		return
+	}
+	for _, f := range fields.List {
+		for _, id := range f.Names {
+			p.add(typ+"."+id.Name, f.Doc, f.Comment)
+		}
+	}
+}
+
+func (p *packageDocs) addFile(af *ast.File) {
+	if af.Doc != nil && p.pkg == "" {
+		p.pkg = af.Doc.Text()
+	}
+	for _, decl := range af.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) != 0 {
+				typ := d.Recv.List[0].Type
+				if st, ok := typ.(*ast.StarExpr); ok {
+					typ = st.X
+				}
+				switch t := typ.(type) {
+				case *ast.IndexExpr:
+					typ = t.X
+				case *ast.IndexListExpr:
+					typ = t.X
+				}
+				id, ok := typ.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				name = id.Name + "." + name
+			}
+			p.add(name, d.Doc)
+		case *ast.GenDecl:
+			// the doc of an ungrouped declaration is attached to the decl
+			var declDoc *ast.CommentGroup
+			if !d.Lparen.IsValid() {
+				declDoc = d.Doc
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					p.add(s.Name.Name, s.Doc, declDoc, s.Comment)
+					switch t := s.Type.(type) {
+					case *ast.StructType:
+						p.addFields(s.Name.Name, t.Fields)
+					case *ast.InterfaceType:
+						p.addFields(s.Name.Name, t.Methods)
+					}
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						p.add(id.Name, s.Doc, declDoc, s.Comment)
+					}
+				}
+			}
+		}
+	}
+}
+
+// loadPackageDocs returns the docs of the package in dir, the source of file
+// filename is replaced with src if src is not empty.
+func loadPackageDocs(ctxt *build.Context, dir, filename, src string) *packageDocs {
+	dir = filepath.Clean(dir)
+	// the unsaved source only changes the docs of its own package, the
+	// docs of other packages are shared by all files
+	stamp := dirStamp(dir)
+	key := dir
+	if src != "" && filepath.Dir(filename) == dir {
+		stamp += "|" + fileCacheKey(filename, src)
+		key += "|" + filename
+	}
+	if v, ok := completionDocs.Get(key); ok {
+		if p := v.(*packageDocs); p.stamp == stamp {
+			return p
+		}
+	}
+	p := &packageDocs{stamp: stamp, docs: make(map[string]string)}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return p
+	}
+	fset := token.NewFileSet()
+	for _, de := range des {
+		name := de.Name()
+		if de.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		var data interface{}
+		if src != "" && path == filename {
+			data = src
+		} else if ok, _ := ctxt.MatchFile(dir, name); !ok {
+			continue
+		}
+		af, _ := parser.ParseFile(fset, path, data, parser.ParseComments)
+		if af != nil {
+			p.addFile(af)
+		}
+	}
+	completionDocs.Add(key, p)
+	return p
+}
+
+// lookup returns the doc of the declaration or member name. Since candidates
+// do not record the type of a member, its doc is only returned if the members
+// with that name are documented the same.
+func (p *packageDocs) lookup(name string) (string, bool) {
+	if s, ok := p.docs[name]; ok {
+		return s, true
+	}
+	var found string
+	n := 0
+	for key, s := range p.docs {
+		if i := strings.IndexByte(key, '.'); i != -1 && key[i+1:] == name {
+			if n > 0 && s != found {
+				return "", false
+			}
+			found = s
+			n++
+		}
+	}
+	return found, n != 0
+}
+
+// packageDir returns the directory of package path, which is resolved
+// relative to the directory of the file being completed.
+func (r *CompletionResolveRequest) packageDir(ctxt *build.Context, path string, cache map[string]string) string {
+	if dir, ok := cache[path]; ok {
+		return dir
+	}
+	srcDir := filepath.Dir(r.Filename)
+	dir := srcDir
+	if path != "" {
+		dir = ""
+		if bp, err := ctxt.Import(path, srcDir, build.FindOnly); err == nil {
+			dir = bp.Dir
+		}
+	}
+	cache[path] = dir
+	return dir
+}
+
+// resolve sets the doc summary and deprecation of the candidates.
+func (r *CompletionResolveRequest) resolve() []GoCodeCandidate {
+	ctxt := contextFromEnv(r.Env, r.Filename)
+	dirs := make(map[string]string)
+	docs := func(path string) *packageDocs {
+		dir := r.packageDir(ctxt, path, dirs)
+		if dir == "" {
+			return nil
+		}
+		return loadPackageDocs(ctxt, dir, r.Filename, r.Src)
+	}
+	var synopsis doc.Package
+	cl := r.Candidates
+	for i := range cl {
+		c := &cl[i]
+		var text string
+		var ok bool
+		if c.Class == "package" {
+			if p := docs(c.PkgPath); p != nil {
+				text, ok = p.pkg, p.pkg != ""
+			}
+		} else {
+			if p := docs(c.PkgPath); p != nil {
+				text, ok = p.lookup(c.Name)
+			}
+			// builtin candidates are not in any package
+			if !ok && c.PkgPath == "" {
+				if p := docs("builtin"); p != nil {
+					text, ok = p.lookup(c.Name)
+				}
+			}
+		}
+		if ok {
+			c.Doc = synopsis.Synopsis(text)
+			c.Deprecated = isDeprecated(text)
+		}
+	}
+	return cl
+}
+
+func (r *CompletionResolveRequest) Call() (interface{}, string) {
+	if r.Filename == "" {
+		return GoCodeResponse{NoGocodeCandidates}, "completion_resolve: missing filename"
+	}
+	r.Filename = filepath.Clean(r.Filename)
+	if r.Candidates == nil {
+		return GoCodeResponse{NoGocodeCandidates}, ""
+	}
+	return GoCodeResponse{Candidates: r.resolve()}, ""
+}
+
+func init() {
+	registry.Register("completion_resolve", func(_ *Broker) Caller {
+		return &CompletionResolveRequest{}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdempsky/gocode/pkg/suggest"
+)
+
+func TestCompletionResolve(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const src = `package a
+
+// Old does something. It is old.
+//
+// Deprecated: use New.
+func Old() {}
+
+// T is a type.
+type T struct {
+	// Field is a field.
+	Field int
+	other int // other is unexported.
+}
+
+// Method is a method of T.
+func (*T) Method() {}
+`
+	candidate := func(class, pkg, name string) GoCodeCandidate {
+		return GoCodeCandidate{Candidate: suggest.Candidate{Class: class, PkgPath: pkg, Name: name}}
+	}
+	r := &CompletionResolveRequest{
+		Filename: filename,
+		Src:      src,
+		Candidates: []GoCodeCandidate{
+			candidate("func", "", "Old"),
+			candidate("type", "", "T"),
+			candidate("var", "", "Field"),
+			candidate("var", "", "other"),
+			candidate("func", "", "Method"),
+			candidate("func", "", "append"),
+			candidate("func", "strings", "Title"),
+			candidate("func", "strings", "TrimSpace"),
+			candidate("package", "strings", "strings"),
+			candidate("func", "", "missing"),
+		},
+	}
+	v, errs := r.Call()
+	if errs != "" {
+		t.Fatal(errs)
+	}
+	cl := v.(GoCodeResponse).Candidates
+	want := []struct {
+		doc        string
+		deprecated bool
+	}{
+		{"Old does something.", true},
+		{"T is a type.", false},
+		{"Field is a field.", false},
+		{"other is unexported.", false},
+		{"Method is a method of T.", false},
+		{"The append built-in function appends elements to the end of a slice.", false},
+		{"Title returns a copy of the string s with all Unicode letters that begin words mapped to their Unicode title case.", true},
+		{"TrimSpace returns a slice (substring) of the string s, with all leading and trailing white space removed, as defined by Unicode.", false},
+		{"Package strings implements simple functions to manipulate UTF-8 encoded strings.", false},
+		{"", false},
+	}
+	for i, w := range want {
+		c := cl[i]
+		if c.Doc != w.doc || c.Deprecated != w.deprecated {
+			t.Errorf("%s: got: %q %t want: %q %t", c.Name, c.Doc, c.Deprecated, w.doc, w.deprecated)
+		}
+	}
+}
+
+func TestLoadPackageDocsKey(t *testing.T) {
+	ctxt := build.Default
+	dir := filepath.Join(ctxt.GOROOT, "src", "strings")
+	// the docs of other packages do not depend on the file being edited
+	p1 := loadPackageDocs(&ctxt, dir, "/src/a/a.go", "package a\n")
+	p2 := loadPackageDocs(&ctxt, dir, "/src/b/b.go", "package b\n")
+	if p1 != p2 {
+		t.Error("expected the docs of strings to be shared")
+	}
+	if _, ok := p1.lookup("TrimSpace"); !ok {
+		t.Error("missing doc of strings.TrimSpace")
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SignatureHelpRequest returns the signature of the call enclosing the
+// cursor.
+type SignatureHelpRequest struct {
+	GoCode
+}
+
+type SignatureParam struct {
+	Name  string `json:"name,omitempty"`
+	Type  string `json:"type"`
+	Label string `json:"label"`
+}
+
+type SignatureTypeArg struct {
+	Name       string `json:"name"`
+	Constraint string `json:"constraint"`
+	Type       string `json:"type"` // instantiated type, if known
+}
+
+type SignatureHelp struct {
+	Name    string `json:"name"`
+	Package string `json:"package,omitempty"`
+	Recv    string `json:"recv,omitempty"`
+	// Label is the signature with the type parameters instantiated, e.g.
+	// "func Max(x, y int) int".
+	Label      string             `json:"label"`
+	TypeParams []SignatureTypeArg `json:"type_params,omitempty"`
+	Params     []SignatureParam   `json:"params"`
+	Results    []SignatureParam   `json:"results,omitempty"`
+	Variadic   bool               `json:"variadic,omitempty"`
+	// ActiveParam is the index of the parameter under the cursor, all
+	// trailing arguments of a variadic call map to the last parameter.
+	ActiveParam int `json:"active_param"`
+}
+
+// parsePackageFiles parses the file being edited and the other files of its
+// package in dir. The bodies of the other files are dropped since only their
+// declarations are needed to type check the file.
+func parsePackageFiles(ctxt *build.Context, filename string, src []byte) (*token.FileSet, *ast.File, []*ast.File, error) {
+	fset := token.NewFileSet()
+	af, err := parser.ParseFile(fset, filename, src, parser.SkipObjectResolution)
+	if af == nil {
+		return nil, nil, nil, err
+	}
+	files := []*ast.File{af}
+	dir := filepath.Dir(filename)
+	des, _ := os.ReadDir(dir)
+	isTest := strings.HasSuffix(filename, "_test.go")
+	for _, de := range des {
+		name := de.Name()
+		path := filepath.Join(dir, name)
+		if de.IsDir() || !strings.HasSuffix(name, ".go") || path == filename {
+			continue
+		}
+		if !isTest && strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, _ := ctxt.MatchFile(dir, name); !ok {
+			continue
+		}
+		f, _ := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if f == nil || f.Name.Name != af.Name.Name {
+			continue
+		}
+		for _, decl := range f.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok {
+				fd.Body = nil
+			}
+		}
+		files = append(files, f)
+	}
+	return fset, af, files, nil
+}
+
+// checkPackageFile type checks the package of filename, whose source is src,
+// ignoring any errors.
+func checkPackageFile(ctxt *build.Context, imp types.ImporterFrom, filename string, src []byte) (*token.FileSet, *ast.File, *types.Package, *types.Info, error) {
+	fset, af, files, err := parsePackageFiles(ctxt, filename, src)
+	if af == nil {
+		return nil, nil, nil, nil, err
+	}
+	info := &types.Info{
+		Types:     make(map[ast.Expr]types.TypeAndValue),
+		Defs:      make(map[*ast.Ident]types.Object),
+		Uses:      make(map[*ast.Ident]types.Object),
+		Instances: make(map[*ast.Ident]types.Instance),
+	}
+	conf := types.Config{
+		Importer:    imp,
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+	pkg, _ := conf.Check(af.Name.Name, fset, files, info)
+	return fset, af, pkg, info, nil
+}
+
+// packageQualifier qualifies types by package name except those of pkg.
+func packageQualifier(pkg *types.Package) types.Qualifier {
+	return func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+}
+
+// enclosingCall returns the innermost call of a function whose arguments
+// contain pos.
+func enclosingCall(af *ast.File, info *types.Info, pos token.Pos) *ast.CallExpr {
+	var call *ast.CallExpr
+	ast.Inspect(af, func(n ast.Node) bool {
+		if n == nil || n.Pos() > pos || (n.End() < pos && n.End().IsValid()) {
+			return false
+		}
+		if c, ok := n.(*ast.CallExpr); ok && c.Lparen < pos && (pos <= c.Rparen || !c.Rparen.IsValid()) {
+			if tv, ok := info.Types[c.Fun]; ok && !tv.IsType() {
+				call = c
+			}
+		}
+		return true
+	})
+	return call
+}
+
+// activeParam returns the index of the argument of call at pos.
+func activeParam(src []byte, tf *token.File, call *ast.CallExpr, pos token.Pos) int {
+	active := 0
+	for i, arg := range call.Args {
+		if arg.End() > pos {
+			break
+		}
+		// the cursor is past the separator that follows the argument
+		if lo, hi := tf.Offset(arg.End()), tf.Offset(pos); bytes.IndexByte(src[lo:hi], ',') != -1 {
+			active = i + 1
+		}
+	}
+	return active
+}
+
+// callObject returns the function object and identifier of the called
+// function of call, if any.
+func callObject(info *types.Info, call *ast.CallExpr) (*ast.Ident, types.Object) {
+	fun := ast.Unparen(call.Fun)
+	switch x := fun.(type) {
+	case *ast.IndexExpr:
+		fun = x.X
+	case *ast.IndexListExpr:
+		fun = x.X
+	}
+	var id *ast.Ident
+	switch x := fun.(type) {
+	case *ast.Ident:
+		id = x
+	case *ast.SelectorExpr:
+		id = x.Sel
+	default:
+		return nil, nil
+	}
+	return id, info.Uses[id]
+}
+
+func signatureTuple(t *types.Tuple, variadic bool, qf types.Qualifier) []SignatureParam {
+	params := make([]SignatureParam, t.Len())
+	for i := 0; i < t.Len(); i++ {
+		v := t.At(i)
+		typ := types.TypeString(v.Type(), qf)
+		if variadic && i == t.Len()-1 {
+			if s, ok := v.Type().(*types.Slice); ok {
+				typ = "..." + types.TypeString(s.Elem(), qf)
+			}
+		}
+		label := typ
+		if v.Name() != "" {
+			label = v.Name() + " " + typ
+		}
+		params[i] = SignatureParam{Name: v.Name(), Type: typ, Label: label}
+	}
+	return params
+}
+
+// signature returns the parameters and results of s, e.g. "(x int) error".
+func (s *SignatureHelp) signature() string {
+	sig := "(" + joinParams(s.Params) + ")"
+	switch {
+	case len(s.Results) == 1 && s.Results[0].Name == "":
+		sig += " " + s.Results[0].Label
+	case len(s.Results) != 0:
+		sig += " (" + joinParams(s.Results) + ")"
+	}
+	return sig
+}
+
+func joinParams(params []SignatureParam) string {
+	a := make([]string, len(params))
+	for i, p := range params {
+		a[i] = p.Label
+	}
+	return strings.Join(a, ", ")
+}
+
+// typeArgs pairs the type parameters of the called function, or the receiver
+// type of the called method, with the type arguments they are instantiated
+// with.
+func typeArgs(info *types.Info, id *ast.Ident, obj types.Object, qf types.Qualifier) []SignatureTypeArg {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil
+	}
+	var tparams *types.TypeParamList
+	var targs *types.TypeList
+	orig := fn.Origin().Type().(*types.Signature)
+	if orig.TypeParams().Len() != 0 {
+		tparams = orig.TypeParams()
+		if inst, ok := info.Instances[id]; ok {
+			targs = inst.TypeArgs
+		}
+	} else if orig.RecvTypeParams().Len() != 0 {
+		tparams = orig.RecvTypeParams()
+		recv := fn.Type().(*types.Signature).Recv().Type()
+		if p, ok := recv.(*types.Pointer); ok {
+			recv = p.Elem()
+		}
+		if named, ok := recv.(*types.Named); ok {
+			targs = named.TypeArgs()
+		}
+	}
+	if tparams == nil {
+		return nil
+	}
+	a := make([]SignatureTypeArg, tparams.Len())
+	for i := 0; i < tparams.Len(); i++ {
+		tp := tparams.At(i)
+		a[i] = SignatureTypeArg{
+			Name:       tp.Obj().Name(),
+			Constraint: types.TypeString(tp.Constraint(), qf),
+		}
+		if targs != nil && i < targs.Len() {
+			a[i].Type = types.TypeString(targs.At(i), qf)
+		}
+	}
+	return a
+}
+
+// signatureHelp type checks the package of filename and returns the
+// signature of the call enclosing cursor, which is a byte offset.
+func signatureHelp(ctxt *build.Context, imp types.ImporterFrom, filename string, src []byte, cursor int) (*SignatureHelp, error) {
+	fset, af, pkg, info, err := checkPackageFile(ctxt, imp, filename, src)
+	if af == nil {
+		return nil, err
+	}
+	tf := fset.File(af.Pos())
+	if cursor > tf.Size() {
+		return nil, errors.New("signature_help: illegal file offset")
+	}
+	pos := tf.Pos(cursor)
+
+	call := enclosingCall(af, info, pos)
+	if call == nil {
+		return nil, errors.New("signature_help: no enclosing call")
+	}
+	sig, ok := info.Types[call.Fun].Type.Underlying().(*types.Signature)
+	if !ok {
+		return nil, errors.New("signature_help: not a function call")
+	}
+	qf := packageQualifier(pkg)
+
+	id, obj := callObject(info, call)
+	res := &SignatureHelp{
+		Params:   signatureTuple(sig.Params(), sig.Variadic(), qf),
+		Results:  signatureTuple(sig.Results(), false, qf),
+		Variadic: sig.Variadic(),
+	}
+	if id != nil {
+		res.Name = id.Name
+		res.TypeParams = typeArgs(info, id, obj, qf)
+	}
+	if obj != nil && obj.Pkg() != nil {
+		res.Package = obj.Pkg().Path()
+	}
+	// the signature of a method value has no receiver
+	if fn, ok := obj.(*types.Func); ok {
+		if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+			res.Recv = types.TypeString(recv.Type(), qf)
+		}
+	}
+
+	if res.Recv != "" {
+		res.Label = "func (" + res.Recv + ") " + res.Name + res.signature()
+	} else {
+		res.Label = "func " + res.Name + res.signature()
+	}
+	res.ActiveParam = activeParam(src, tf, call, pos)
+	if n := len(res.Params); sig.Variadic() && res.ActiveParam >= n {
+		res.ActiveParam = n - 1
+	}
+	return res, nil
+}
+
+// signatureHelp returns the signature of the call enclosing the cursor.
+func (g *GoCode) signatureHelp() (*SignatureHelp, error) {
+	cursor, err := g.bytePos()
+	if err != nil {
+		return nil, err
+	}
+	ctxt := g.buildContext()
+	return signatureHelp(ctxt, g.importer(ctxt), g.filepath(), []byte(g.Src), cursor)
+}
+
+func (r *SignatureHelpRequest) Call() (interface{}, string) {
+	start := time.Now()
+	res, err := r.signatureHelp()
+	if err != nil {
+		return nil, err.Error()
+	}
+	logger.Named("signature_help").Debug("signature_help",
+		zap.String("filename", r.shortFilename()), zap.String("name", res.Name),
+		zap.Duration("duration", time.Since(start)))
+	return res, ""
+}
+
+func init() {
+	registry.Register("signature_help", func(_ *Broker) Caller {
+		return &SignatureHelpRequest{}
+	})
+}
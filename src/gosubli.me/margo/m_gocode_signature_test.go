@@ -0,0 +1,113 @@
+package main
+
+import (
+	"go/build"
+	"go/importer"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSignatureHelp(t *testing.T) {
+	dir := t.TempDir()
+	// declarations in another file of the package
+	const decls = `package p
+
+type A struct{}
+
+func (A) Do(x int) {}
+
+type B struct{}
+
+func (*B) Do(s string, n ...int) error { return nil }
+
+func Max[T int | float64](x, y T) T { return x }
+
+type List[T any] struct{}
+
+func (l *List[T]) Push(v T) {}
+`
+	if err := os.WriteFile(filepath.Join(dir, "decls.go"), []byte(decls), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "p.go")
+	imp := importer.Default().(types.ImporterFrom)
+
+	tests := []struct {
+		src        string
+		label      string
+		typeParams []SignatureTypeArg
+		active     int
+	}{
+		{
+			src:    `fmt.Printf("%d %d", 1, $2)`,
+			label:  "func Printf(format string, a ...any) (n int, err error)",
+			active: 1,
+		},
+		{
+			src:    "strings.Replace(\n\t\t\"a\",\n\t\t\"b\",$\n\t)",
+			label:  "func Replace(s string, old string, new string, n int) string",
+			active: 2,
+		},
+		{
+			src:    `fmt.Println(strings.ToUpper($"a"))`,
+			label:  "func ToUpper(s string) string",
+			active: 0,
+		},
+		{
+			src:    `new(B).Do("a", 1, 2, $)`,
+			label:  "func (*B) Do(s string, n ...int) error",
+			active: 1,
+		},
+		{
+			src:    `A{}.Do($)`,
+			label:  "func (A) Do(x int)",
+			active: 0,
+		},
+		{
+			src:        `Max(1.5, $2)`,
+			label:      "func Max(x float64, y float64) float64",
+			typeParams: []SignatureTypeArg{{Name: "T", Constraint: "int | float64", Type: "float64"}},
+			active:     1,
+		},
+		{
+			src:        `new(List[string]).Push($)`,
+			label:      "func (*List[string]) Push(v string)",
+			typeParams: []SignatureTypeArg{{Name: "T", Constraint: "any", Type: "string"}},
+			active:     0,
+		},
+	}
+	for _, x := range tests {
+		src := "package p\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n\nvar _ = strings.ToUpper\n\nfunc f() {\n\t" +
+			x.src + "\n\tfmt.Println()\n}\n"
+		cursor := strings.Index(src, "$")
+		src = src[:cursor] + src[cursor+1:]
+		sig, err := signatureHelp(&build.Default, imp, filename, []byte(src), cursor)
+		if err != nil {
+			t.Errorf("%s: %v", x.src, err)
+			continue
+		}
+		if sig.Label != x.label {
+			t.Errorf("%s: Label = %q; want: %q", x.src, sig.Label, x.label)
+		}
+		if !reflect.DeepEqual(sig.TypeParams, x.typeParams) {
+			t.Errorf("%s: TypeParams = %+v; want: %+v", x.src, sig.TypeParams, x.typeParams)
+		}
+		if sig.ActiveParam != x.active {
+			t.Errorf("%s: ActiveParam = %d; want: %d", x.src, sig.ActiveParam, x.active)
+		}
+	}
+
+	g := &GoCode{Fn: filename}
+	src := "package p\n\nfunc f() { Max(1, 2) }\n"
+	cl, err := g.calltips(g.buildContext(), filename, []byte(src), strings.Index(src, "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cl) != 1 || cl[0].Name != "Max" || cl[0].Type != "func(x int, y int) int" {
+		t.Errorf("calltips = %+v; want: Max func(x int, y int) int", cl)
+	}
+}
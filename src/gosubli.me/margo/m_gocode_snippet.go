@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/mdempsky/gocode/pkg/suggest"
+)
+
+// snippetEscaper escapes the characters that have a special meaning in
+// snippets.
+var snippetEscaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`)
+
+// stripTypeParams removes the type parameters from the function type typ
+// since they are not valid in a function type expression and are usually
+// inferred.
+func stripTypeParams(typ string) string {
+	if !strings.HasPrefix(typ, "func[") {
+		return typ
+	}
+	depth := 0
+	for i := len("func"); i < len(typ); i++ {
+		switch typ[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return "func" + typ[i+1:]
+			}
+		}
+	}
+	return typ
+}
+
+// funcParams returns the parameter names of function type typ, unnamed
+// parameters are named by their type. Variadic parameters are left out since
+// they may be omitted from the call.
+func funcParams(typ string) ([]string, bool) {
+	expr, err := parser.ParseExpr(stripTypeParams(typ))
+	if err != nil {
+		return nil, false
+	}
+	ft, ok := expr.(*ast.FuncType)
+	if !ok {
+		return nil, false
+	}
+	var params []string
+	for _, field := range ft.Params.List {
+		if _, ok := field.Type.(*ast.Ellipsis); ok {
+			continue
+		}
+		var names []string
+		for _, id := range field.Names {
+			names = append(names, id.Name)
+		}
+		if len(names) == 0 {
+			var buf bytes.Buffer
+			printer.Fprint(&buf, token.NewFileSet(), field.Type)
+			names = append(names, buf.String())
+		}
+		params = append(params, names...)
+	}
+	return params, true
+}
+
+// isCompositeType reports if a type candidate of type typ can be used in a
+// composite literal.
+func isCompositeType(typ string) bool {
+	return typ == "struct" || strings.HasPrefix(typ, "struct{") ||
+		strings.HasPrefix(typ, "map[") || strings.HasPrefix(typ, "[")
+}
+
+// candidateSnippet returns the snippet that inserts candidate c: function
+// calls have a placeholder for each non-variadic parameter and composite types
+// braces.
+func candidateSnippet(c suggest.Candidate) string {
+	name := snippetEscaper.Replace(c.Name)
+	switch {
+	case c.Class == "type" && isCompositeType(c.Type):
+		return name + "{$1}"
+	case (c.Class == "func" || c.Class == "var") && strings.HasPrefix(c.Type, "func"):
+		params, ok := funcParams(c.Type)
+		if !ok {
+			break
+		}
+		var b strings.Builder
+		b.WriteString(name)
+		b.WriteByte('(')
+		for i, p := range params {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("${" + strconv.Itoa(i+1) + ":" + snippetEscaper.Replace(p) + "}")
+		}
+		b.WriteByte(')')
+		return b.String()
+	}
+	return name
+}
+
+// addSnippets sets the insert text of the candidates. Snippets are not used
+// if the cursor is followed by a call or composite literal since the
+// parentheses or braces would be duplicated.
+func (g *GoCode) addSnippets(cl []GoCodeCandidate) {
+	cursor, err := g.bytePos()
+	plain := g.PlainText || err != nil
+	if !plain {
+		rest := strings.TrimLeft(g.Src[cursor:], " \t")
+		plain = strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, "{")
+	}
+	for i := range cl {
+		if plain {
+			cl[i].InsertText = cl[i].Name
+		} else {
+			cl[i].InsertText = candidateSnippet(cl[i].Candidate)
+		}
+	}
+}
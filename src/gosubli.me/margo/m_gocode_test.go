@@ -1,8 +1,12 @@
 package main
 
 import (
+	"go/build"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/mdempsky/gocode/pkg/suggest"
 )
 
 type extractCursorLineTest struct {
@@ -93,3 +97,290 @@ func main() {
 	// 	{}
 	// }
 }
+
+func TestSelectorAt(t *testing.T) {
+	tests := []struct {
+		src          string
+		name, prefix string
+		ok           bool
+	}{
+		{"x := strconv.Ito$", "strconv", "Ito", true},
+		{"x := strconv.$", "strconv", "", true},
+		{"x := a.b.c$", "b", "c", true},
+		{"x := strconv$", "", "", false},
+		{"x := f().$", "", "", false},
+	}
+	for _, x := range tests {
+		cursor := strings.Index(x.src, "$")
+		name, prefix, _, ok := selectorAt([]byte(x.src[:cursor]), cursor)
+		if name != x.name || prefix != x.prefix || ok != x.ok {
+			t.Errorf("selectorAt(%q) = %q, %q, %t; want: %q, %q, %t",
+				x.src, name, prefix, ok, x.name, x.prefix, x.ok)
+		}
+	}
+}
+
+func TestIsPackageName(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a.go": "package lint\n",
+	})
+	for _, x := range []struct {
+		src  string
+		want bool
+	}{
+		{"package lint\n\nimport \"os\"\n\nfunc A() { os.fo$ }\n", true},
+		{"package lint\n\nfunc A() { strconv.fo$ }\n", true},
+		{"package lint\n\nimport \"os\"\n\nfunc A(os []int) { os.fo$ }\n", false},
+		{"package lint\n\nimport \"os\"\n\nfunc A(x struct{ os []int }) { x.os.fo$ }\n", false},
+		{"package lint\n\nfunc A(s []int) { s.fo$ }\n", false},
+	} {
+		cursor := strings.Index(x.src, "$")
+		src := []byte(strings.Replace(x.src, "$", "", 1))
+		name, _, off, ok := selectorAt(src, cursor)
+		if !ok {
+			t.Fatalf("%q: no selector", x.src)
+		}
+		if got := isPackageName(&build.Default, filepath.Join(dir, "a.go"), src, name, off); got != x.want {
+			t.Errorf("isPackageName(%q) = %t; want: %t", x.src, got, x.want)
+		}
+	}
+}
+
+func TestKnownPackages(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a.go":              "package lint\n",
+		"util/util.go":      "package util\n\nfunc Helper() {}\n",
+		"internal/x/x.go":   "package x\n",
+		"cmd/tool/main.go":  "package main\n\nfunc main() {}\n",
+		"yaml.v3/yaml.go":   "package yaml\n",
+		"go-errors/errs.go": "package errors\n",
+	})
+	// a cold cache only has the packages found by importPaths
+	pkgs, err := knownPackages(nil, "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) == 0 || pkgs[0].Kind != packageStd {
+		t.Fatalf("cold cache: expected std packages got: %+v", pkgs)
+	}
+
+	pkgs, err = loadKnownPackages(nil, "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := knownPackages(nil, "", dir); len(cached) != len(pkgs) {
+		t.Errorf("expected the loaded packages to be cached: got: %d want: %d", len(cached), len(pkgs))
+	}
+	kinds := make(map[string]int)
+	for _, p := range pkgs {
+		kinds[p.Path] = p.Kind
+	}
+	want := map[string]int{
+		"strconv":                     packageStd,
+		"example.com/lint/util":       packageMainModule,
+		"example.com/lint/internal/x": packageMainModule,
+	}
+	for path, kind := range want {
+		if k, ok := kinds[path]; !ok || k != kind {
+			t.Errorf("%s: kind = %d (found: %t); want: %d", path, k, ok, kind)
+		}
+	}
+	for _, path := range []string{"example.com/lint/cmd/tool", "internal/abi"} {
+		if _, ok := kinds[path]; ok {
+			t.Errorf("%s: should not be importable", path)
+		}
+	}
+	for i := 1; i < len(pkgs); i++ {
+		if pkgs[i-1].Kind > pkgs[i].Kind {
+			t.Fatalf("packages not ranked by kind: %+v %+v", pkgs[i-1], pkgs[i])
+		}
+	}
+
+	for path, name := range map[string]string{
+		"gopkg.in/yaml.v3":            "yaml",
+		"github.com/go-errors/errors": "errors",
+		"example.com/mod/v2":          "mod",
+		"math/rand/v2":                "rand",
+	} {
+		if s := assumedPackageName(path); s != name {
+			t.Errorf("assumedPackageName(%q) = %q; want: %q", path, s, name)
+		}
+	}
+}
+
+func TestUnimportedCandidates(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a.go":   "package lint\n",
+		"log.go": "package lint\n\nvar log = struct{ Print func() }{}\n",
+	})
+	complete := func(src string) []GoCodeCandidate {
+		t.Helper()
+		cursor := strings.Index(src, "$")
+		g := &GoCode{
+			Fn:  filepath.Join(dir, "a.go"),
+			Src: strings.Replace(src, "$", "", 1),
+			Pos: cursor,
+		}
+		return g.unimportedCandidates(g.buildContext(), nil)
+	}
+
+	const src = "package lint\n\nimport \"fmt\"\n\nfunc A() {\n\tfmt.Println(strconv.Ito$)\n}\n"
+	cl := complete(src)
+	if len(cl) != 1 || cl[0].Name != "Itoa" || cl[0].PkgPath != "strconv" || cl[0].Class != "func" {
+		t.Fatalf("unexpected candidates: %+v", cl)
+	}
+	out, err := applyTextEdits([]byte(strings.Replace(src, "$", "", 1)), cl[0].AdditionalEdits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "package lint\n\nimport (\n\t\"fmt\"\n\t\"strconv\"\n)\n\nfunc A() {\n\tfmt.Println(strconv.Ito)\n}\n"
+	if string(out) != want {
+		t.Errorf("AdditionalEdits:\ngot:\n%s\nwant:\n%s", out, want)
+	}
+
+	// packages with the same name are ranked
+	var paths []string
+	for _, c := range complete("package lint\n\nfunc A() { rand.Int$ }\n") {
+		if len(paths) == 0 || paths[len(paths)-1] != c.PkgPath {
+			paths = append(paths, c.PkgPath)
+		}
+	}
+	if len(paths) == 0 || paths[0] != "math/rand" {
+		t.Errorf("expected math/rand to be ranked first got: %q", paths)
+	}
+
+	// declared identifiers are not packages
+	for _, src := range []string{
+		"package lint\n\nfunc A(strconv int) { strconv.I$ }\n",
+		"package lint\n\nimport strconv \"fmt\"\n\nfunc A() { strconv.P$ }\n",
+		"package lint\n\nvar strconv struct{}\n\nfunc A() { strconv.I$ }\n",
+		"package lint\n\nfunc A() { log.P$ }\n", // declared in log.go
+	} {
+		if cl := complete(src); len(cl) != 0 {
+			t.Errorf("%q: unexpected candidates: %+v", src, cl)
+		}
+	}
+
+	// the candidates found by gocode are kept
+	g := &GoCode{
+		Fn:  filepath.Join(dir, "a.go"),
+		Src: strings.Replace(src, "$", "", 1),
+		Pos: strings.Index(src, "$"),
+	}
+	found := []suggest.Candidate{{Class: "func", Name: "Itoa", PkgPath: "example.com/strconv"}}
+	if cl := g.unimportedCandidates(g.buildContext(), found); len(cl) != 1 || cl[0].PkgPath != "example.com/strconv" ||
+		len(cl[0].AdditionalEdits) != 0 {
+		t.Errorf("unexpected candidates: %+v", cl)
+	}
+}
+
+func TestCallUnimportedPackage(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a.go": "package lint\n",
+	})
+	const src = "package lint\n\nfunc A() {\n\tstrconv.I$\n}\n"
+	g := &GoCode{
+		Fn:  filepath.Join(dir, "a.go"),
+		Src: strings.Replace(src, "$", "", 1),
+		Pos: strings.Index(src, "$"),
+	}
+	v, errStr := g.Call()
+	if errStr != "" {
+		t.Fatal(errStr)
+	}
+	cl := v.(GoCodeResponse).Candidates
+	if len(cl) == 0 {
+		t.Fatal("no candidates")
+	}
+	// every member of the unimported package must add its import
+	for _, c := range cl {
+		if c.PkgPath != "strconv" || len(c.AdditionalEdits) == 0 {
+			t.Errorf("candidate does not import strconv: %+v", c)
+		}
+	}
+}
+
+func TestCandidateSnippet(t *testing.T) {
+	tests := []struct {
+		c    suggest.Candidate
+		want string
+	}{
+		{suggest.Candidate{Class: "func", Name: "Itoa", Type: "func(i int) string"}, "Itoa(${1:i})"},
+		{suggest.Candidate{Class: "func", Name: "Close", Type: "func() error"}, "Close()"},
+		{suggest.Candidate{Class: "func", Name: "Printf", Type: "func(format string, a ...any) (n int, err error)"},
+			"Printf(${1:format})"},
+		{suggest.Candidate{Class: "func", Name: "Join", Type: "func(...string) string"}, "Join()"},
+		{suggest.Candidate{Class: "func", Name: "Copy", Type: "func(dst, src []byte) int"}, "Copy(${1:dst}, ${2:src})"},
+		{suggest.Candidate{Class: "func", Name: "F", Type: "func(int, map[string]int)"}, "F(${1:int}, ${2:map[string]int})"},
+		{suggest.Candidate{Class: "func", Name: "Max", Type: "func[T cmp.Ordered](x T, y ...T) T"}, "Max(${1:x})"},
+		{suggest.Candidate{Class: "var", Name: "fn", Type: "func(s struct{ a int })"}, "fn(${1:s})"},
+		{suggest.Candidate{Class: "var", Name: "x", Type: "int"}, "x"},
+		{suggest.Candidate{Class: "type", Name: "Builder", Type: "struct"}, "Builder{$1}"},
+		{suggest.Candidate{Class: "type", Name: "Set", Type: "map[string]bool"}, "Set{$1}"},
+		{suggest.Candidate{Class: "type", Name: "Reader", Type: "interface"}, "Reader"},
+		{suggest.Candidate{Class: "type", Name: "Duration", Type: "int64"}, "Duration"},
+		{suggest.Candidate{Class: "package", Name: "strconv"}, "strconv"},
+	}
+	for _, x := range tests {
+		if got := candidateSnippet(x.c); got != x.want {
+			t.Errorf("candidateSnippet(%+v) = %q; want: %q", x.c, got, x.want)
+		}
+	}
+
+	cl := newGoCodeCandidates([]suggest.Candidate{tests[0].c})
+	g := &GoCode{Src: "package a\n\nvar _ = strconv.I(1)\n"}
+	g.Pos = strings.Index(g.Src, "(")
+	g.addSnippets(cl)
+	if cl[0].InsertText != "Itoa" {
+		t.Errorf("expected plain text before a call got: %q", cl[0].InsertText)
+	}
+	g = &GoCode{Src: "package a\n\nvar _ = strconv.I\n", PlainText: true}
+	g.Pos = strings.Index(g.Src, "I\n") + 1
+	g.addSnippets(cl)
+	if cl[0].InsertText != "Itoa" {
+		t.Errorf("expected plain text got: %q", cl[0].InsertText)
+	}
+	g.PlainText = false
+	g.addSnippets(cl)
+	if cl[0].InsertText != "Itoa(${1:i})" {
+		t.Errorf("expected snippet got: %q", cl[0].InsertText)
+	}
+}
+
+func TestGoCodeImporter(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		".mgo_build_tags": "mgo\n",
+		"a.go":            "package lint\n",
+	})
+	env := map[string]string{
+		"GOOS":    "plan9",
+		"GOFLAGS": "-mod=mod -tags=foo,bar",
+	}
+	g := &GoCode{Env: env, Fn: "a.go", Dir: dir, Src: "package lint\n", InstallSuffix: "race"}
+	ctxt := g.buildContext()
+	if ctxt.GOOS != "plan9" || ctxt.InstallSuffix != "race" {
+		t.Errorf("GOOS = %q InstallSuffix = %q; want: plan9 race", ctxt.GOOS, ctxt.InstallSuffix)
+	}
+	for _, tag := range []string{"foo", "bar", "mgo"} {
+		if !stringsContain(ctxt.BuildTags, tag) {
+			t.Errorf("BuildTags = %q; missing: %q", ctxt.BuildTags, tag)
+		}
+	}
+
+	// the context is matched to the file
+	g2 := &GoCode{Env: env, Fn: filepath.Join(dir, "a_windows.go"), Src: "package lint\n"}
+	if ctxt := g2.buildContext(); ctxt.GOOS != "windows" {
+		t.Errorf("GOOS = %q; want: windows", ctxt.GOOS)
+	}
+
+	gocodeImporters.Clear()
+	g.importer(g.buildContext())
+	g.importer(g.buildContext())
+	if n := gocodeImporters.Len(); n != 1 {
+		t.Errorf("expected 1 cached importer got: %d", n)
+	}
+	g2.importer(g2.buildContext())
+	if n := gocodeImporters.Len(); n != 2 {
+		t.Errorf("expected 2 cached importers got: %d", n)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mdempsky/gocode/pkg/suggest"
+	"go.uber.org/zap"
+)
+
+// maxUnimportedPackages is the maximum number of packages with the same name
+// whose members are suggested for an unimported package.
+const maxUnimportedPackages = 3
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// selectorAt returns the operand and partial selector of the selector
+// expression that ends at cursor and the offset of the operand, e.g. "strconv"
+// and "Ito" for "strconv.Ito|".
+func selectorAt(src []byte, cursor int) (name, prefix string, off int, ok bool) {
+	if cursor > len(src) {
+		return "", "", 0, false
+	}
+	identStart := func(end int) int {
+		for end > 0 {
+			r, size := utf8.DecodeLastRune(src[:end])
+			if !isIdentRune(r) {
+				break
+			}
+			end -= size
+		}
+		return end
+	}
+	i := identStart(cursor)
+	if i == 0 || src[i-1] != '.' {
+		return "", "", 0, false
+	}
+	j := identStart(i - 1)
+	name = string(src[j : i-1])
+	if !token.IsIdentifier(name) {
+		return "", "", 0, false
+	}
+	return name, string(src[i:cursor]), j, true
+}
+
+// declaresName reports if name is declared at the package level of af.
+func declaresName(af *ast.File, name string) bool {
+	for _, decl := range af.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil && d.Name.Name == name {
+				return true
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						if id.Name == name {
+							return true
+						}
+					}
+				case *ast.TypeSpec:
+					if s.Name.Name == name {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// importsName reports if af imports a package named name.
+func importsName(af *ast.File, name string) bool {
+	for _, spec := range af.Imports {
+		var s string
+		if spec.Name != nil {
+			s = spec.Name.Name
+		} else {
+			s = assumedPackageName(strings.Trim(spec.Path.Value, "`\""))
+		}
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredAt reports if the identifier at offset off of af is declared in
+// the file.
+func declaredAt(fset *token.FileSet, af *ast.File, off int) bool {
+	tf := fset.File(af.Pos())
+	declared := false
+	ast.Inspect(af, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Obj != nil && tf.Offset(id.Pos()) == off {
+			declared = true
+		}
+		return !declared
+	})
+	return declared
+}
+
+// isUnimportedName reports if the identifier name at offset off of src does
+// not refer to an import or an identifier declared in the file or at the
+// package level of the other files of its package.
+func isUnimportedName(ctxt *build.Context, filename string, src []byte, name string, off int) bool {
+	fset := token.NewFileSet()
+	af, _ := parser.ParseFile(fset, filename, src, 0)
+	if af == nil {
+		return false
+	}
+	if importsName(af, name) || af.Scope.Lookup(name) != nil || declaredAt(fset, af, off) {
+		return false
+	}
+	_, _, files, _ := parsePackageFiles(ctxt, filename, src)
+	for _, f := range files {
+		if declaresName(f, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPackageName reports if the operand name at offset off of src refers to an
+// import of the file or to a package it does not import (see
+// isUnimportedName).
+func isPackageName(ctxt *build.Context, filename string, src []byte, name string, off int) bool {
+	if off > 0 && src[off-1] == '.' {
+		return false // selector of another operand
+	}
+	fset := token.NewFileSet()
+	af, _ := parser.ParseFile(fset, filename, src, 0)
+	if af == nil || declaredAt(fset, af, off) {
+		return false
+	}
+	if importsName(af, name) {
+		return af.Scope.Lookup(name) == nil
+	}
+	return isUnimportedName(ctxt, filename, src, name, off)
+}
+
+// objectClass returns the gocode candidate class of obj.
+func objectClass(obj types.Object) string {
+	switch obj.(type) {
+	case *types.Const:
+		return "const"
+	case *types.Func:
+		return "func"
+	case *types.TypeName:
+		return "type"
+	case *types.PkgName:
+		return "package"
+	}
+	return "var"
+}
+
+// objectType returns the gocode candidate type of obj.
+func objectType(obj types.Object) string {
+	qf := func(p *types.Package) string { return p.Name() }
+	if tn, ok := obj.(*types.TypeName); ok {
+		switch tn.Type().Underlying().(type) {
+		case *types.Struct:
+			return "struct"
+		case *types.Interface:
+			return "interface"
+		}
+		return types.TypeString(tn.Type().Underlying(), qf)
+	}
+	return types.TypeString(obj.Type(), qf)
+}
+
+// packageCandidates returns the exported members of pkg that start with
+// prefix, ignoring case.
+func packageCandidates(pkg *types.Package, prefix string) []suggest.Candidate {
+	prefix = strings.ToLower(prefix)
+	scope := pkg.Scope()
+	var cl []suggest.Candidate
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() || !strings.HasPrefix(strings.ToLower(name), prefix) {
+			continue
+		}
+		cl = append(cl, suggest.Candidate{
+			Class:   objectClass(obj),
+			PkgPath: pkg.Path(),
+			Name:    name,
+			Type:    objectType(obj),
+		})
+	}
+	sort.SliceStable(cl, func(i, j int) bool {
+		if cl[i].Class != cl[j].Class {
+			return cl[i].Class < cl[j].Class
+		}
+		return cl[i].Name < cl[j].Name
+	})
+	return cl
+}
+
+// unimportedCandidates adds the candidates for the selector at the cursor to
+// cl if gocode found none and its operand is the name of a package the file
+// does not import. The members of the best ranked packages with that name are
+// added, each with an edit that adds the import of its package.
+func (g *GoCode) unimportedCandidates(ctxt *build.Context, cl []suggest.Candidate) []GoCodeCandidate {
+	res := newGoCodeCandidates(cl)
+	if len(cl) != 0 {
+		return res
+	}
+	cursor, err := g.bytePos()
+	if err != nil {
+		return res
+	}
+	filename := g.filepath()
+	src := []byte(g.Src)
+	name, prefix, off, ok := selectorAt(src, cursor)
+	if !ok || !isUnimportedName(ctxt, filename, src, name, off) {
+		return res
+	}
+
+	log := logger.Named("gocode").With(zap.String("filename", g.shortFilename()),
+		zap.String("package", name))
+	dir := filepath.Dir(filename)
+	known, err := knownPackages(g.Env, g.InstallSuffix, dir)
+	if err != nil {
+		log.Debug("listing packages", zap.Error(err))
+	}
+	imp := g.importer(ctxt)
+	n := 0
+	for _, p := range known {
+		if p.Name != name {
+			continue
+		}
+		if n++; n > maxUnimportedPackages {
+			break
+		}
+		pkg, err := imp.ImportFrom(p.Path, dir, 0)
+		if err != nil || pkg.Name() != name {
+			log.Debug("importing package", zap.String("path", p.Path), zap.Error(err))
+			continue
+		}
+		edits, err := addImportEdits(filename, src, p.Path)
+		if err != nil {
+			log.Debug("adding import", zap.String("path", p.Path), zap.Error(err))
+			continue
+		}
+		for _, c := range packageCandidates(pkg, prefix) {
+			res = append(res, GoCodeCandidate{Candidate: c, AdditionalEdits: edits})
+		}
+	}
+	return res
+}
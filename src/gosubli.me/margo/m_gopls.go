@@ -141,18 +141,8 @@ func GuruCmd(ctx context.Context, cmd, filename string, env map[string]string, s
 	ctx, cancel = context.WithCancel(context.Background())
 	defer cancel()
 
-	parent := build.Default
-	if s, ok := env["GOPATH"]; ok && isDir(s) {
-		parent.GOPATH = s
-	}
-	if s, ok := env["GOROOT"]; ok && isDir(s) {
-		parent.GOROOT = s
-	}
-	if s := env["GOOS"]; s != "" {
-		parent.GOOS = s
-	}
-
-	ctxt, err := buildutil.MatchContext(&parent, filename, nil)
+	parent := contextFromEnv(env, filename)
+	ctxt, err := buildutil.MatchContext(parent, filename, nil)
 	if err != nil {
 		return err
 	}
@@ -217,20 +207,8 @@ func (r *ReferencesRequest) GuruWhat() (*GuruWhatResponse, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	parent := build.Default
-	if r.Env != nil {
-		if s, ok := r.Env["GOPATH"]; ok && isDir(s) {
-			parent.GOPATH = s
-		}
-		if s, ok := r.Env["GOROOT"]; ok && isDir(s) {
-			parent.GOROOT = s
-		}
-		if s := r.Env["GOOS"]; s != "" {
-			parent.GOOS = s
-		}
-	}
-
-	ctxt, err := buildutil.MatchContext(&parent, r.Filename, nil)
+	parent := contextFromEnv(r.Env, r.Filename)
+	ctxt, err := buildutil.MatchContext(parent, r.Filename, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
 	"go/build"
@@ -9,14 +11,18 @@ import (
 	"os"
 	pathpkg "path"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/charlievieth/buildutil"
+	"github.com/charlievieth/buildutil/contextutil"
 	"github.com/charlievieth/pkgs"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"gosubli.me/margo/internal/lru"
 )
 
 type mImportPaths struct {
@@ -116,7 +122,7 @@ type importsPathCacheEntry struct {
 	Imports []string
 }
 
-// project root => *importsPathCacheEntry
+// build context and project root => *importsPathCacheEntry
 var importsPathCache sync.Map
 
 func init() {
@@ -250,9 +256,14 @@ func projectRoot(ctxt *build.Context, dirname string) string {
 }
 
 func importPaths(environ map[string]string, installSuffix, importDir string) ([]string, error) {
+	ctxt := contextFromEnv(environ, importDir)
+	if installSuffix != "" {
+		ctxt.InstallSuffix = installSuffix
+	}
 
-	cacheRoot := projectRoot(contextFromEnv(environ), importDir)
-	if v, ok := importsPathCache.Load(cacheRoot); ok {
+	// the session build config changes which packages match
+	cacheKey := contextKey(ctxt) + "|" + projectRoot(ctxt, importDir)
+	if v, ok := importsPathCache.Load(cacheKey); ok {
 		if e, _ := v.(*importsPathCacheEntry); e != nil {
 			a := make([]string, len(e.Imports))
 			copy(a, e.Imports)
@@ -260,33 +271,7 @@ func importPaths(environ map[string]string, installSuffix, importDir string) ([]
 		}
 	}
 
-	// TODO:
-	// 	- Consider adding os.GOROOT and os.GOPATH to environ
-	// 	- Check for duplicate paths
-	var root string
-	if s := environ["GOROOT"]; s != "" {
-		root = s
-	} else {
-		root = runtime.GOROOT()
-	}
-	var path string
-	if s := environ["GOPATH"]; s != "" {
-		path = s
-	} else {
-		path = os.Getenv("GOPATH")
-	}
-	ctxt := build.Default
-	if root != "" {
-		ctxt.GOROOT = root
-	}
-	if path != "" {
-		ctxt.GOPATH = path
-	}
-	if installSuffix != "" {
-		ctxt.InstallSuffix = installSuffix
-	}
-
-	paths, err := pkgs.Walk(&ctxt, importDir)
+	paths, err := pkgs.Walk(ctxt, importDir)
 	if len(paths) != 0 {
 		sort.Strings(paths)
 	}
@@ -294,13 +279,219 @@ func importPaths(environ map[string]string, installSuffix, importDir string) ([]
 		return paths, err
 	}
 
-	importsPathCache.Store(cacheRoot, &importsPathCacheEntry{
+	importsPathCache.Store(cacheKey, &importsPathCacheEntry{
 		Created: time.Now(),
 		Imports: append([]string(nil), paths...),
 	})
 	return paths, nil
 }
 
+// Package kinds in the order they are ranked.
+const (
+	packageStd = iota
+	packageMainModule
+	packageDependency
+	packageGopath
+)
+
+// importPackage is a package that can be imported from a directory.
+type importPackage struct {
+	Path string
+	Name string
+	Kind int
+	Dir  string // may be empty
+	Doc  string // synopsis, may be empty
+}
+
+// assumedPackageName returns the package name of import path ipath assuming
+// that it matches the last path element with any "go-" prefix and major
+// version suffix removed.
+func assumedPackageName(ipath string) string {
+	base := pathpkg.Base(ipath)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			if dir := pathpkg.Dir(ipath); dir != "." {
+				base = pathpkg.Base(dir)
+			}
+		}
+	}
+	if i := strings.IndexByte(base, '.'); i > 0 {
+		base = base[:i] // gopkg.in/yaml.v3
+	}
+	base = strings.TrimPrefix(base, "go-")
+	return strings.ReplaceAll(base, "-", "_")
+}
+
+// isInternalPath reports if ipath contains an "internal" element.
+func isInternalPath(ipath string) bool {
+	return ipath == "internal" || strings.HasPrefix(ipath, "internal/") ||
+		strings.HasSuffix(ipath, "/internal") || strings.Contains(ipath, "/internal/")
+}
+
+// rankPackages sorts pkgs by kind then path length and path.
+func rankPackages(pkgs []importPackage) {
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		p1, p2 := &pkgs[i], &pkgs[j]
+		if p1.Kind != p2.Kind {
+			return p1.Kind < p2.Kind
+		}
+		if len(p1.Path) != len(p2.Path) {
+			return len(p1.Path) < len(p2.Path)
+		}
+		return p1.Path < p2.Path
+	})
+}
+
+type knownPackagesEntry struct {
+	Created time.Time
+	Pkgs    []importPackage
+}
+
+var (
+	knownPackagesCache = lru.New(16)
+	knownPackagesGroup singleflight.Group
+)
+
+// listModulePackages returns the standard library packages and packages of
+// the main module, and its dependencies, containing dir.
+func listModulePackages(ctxt *build.Context, dir string) ([]importPackage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	const format = "{{.ImportPath}}\t{{.Name}}\t{{.Standard}}\t{{with .Module}}{{.Main}}{{end}}\t{{.Dir}}\t{{.Doc}}"
+	cmd := buildutil.GoCommandContext(ctx, ctxt, "go", "list", "-e", "-f", format, "std", "all")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("go list: %s", msg)
+		}
+		return nil, err
+	}
+	var pkgs []importPackage
+	for _, line := range strings.Split(string(out), "\n") {
+		a := strings.SplitN(line, "\t", 6)
+		if len(a) != 6 || a[1] == "" || a[1] == "main" {
+			continue
+		}
+		p := importPackage{Path: a[0], Name: a[1], Kind: packageDependency, Dir: a[4], Doc: a[5]}
+		switch {
+		case a[2] == "true":
+			p.Kind = packageStd
+		case a[3] == "true":
+			p.Kind = packageMainModule
+		}
+		if p.Kind != packageMainModule && isInternalPath(p.Path) {
+			continue
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// knownPackagesTTL is how long the known packages of a directory are used
+// before they are refreshed.
+const knownPackagesTTL = 2 * time.Minute
+
+func knownPackagesKey(environ map[string]string, installSuffix, dir string) (*build.Context, string) {
+	ctxt := contextFromEnv(environ, dir)
+	if installSuffix != "" {
+		ctxt.InstallSuffix = installSuffix
+	}
+	return ctxt, projectRoot(ctxt, dir) + "|" + contextKey(ctxt)
+}
+
+// gopathPackages appends the packages of import paths, whose names are
+// assumed from their import path, that are not in seen to pkgs.
+func gopathPackages(pkgs []importPackage, paths []string, seen map[string]bool) []importPackage {
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		kind := packageGopath
+		if !strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+			kind = packageStd
+		}
+		if isInternalPath(path) {
+			continue
+		}
+		pkgs = append(pkgs, importPackage{
+			Path: path,
+			Name: assumedPackageName(path),
+			Kind: kind,
+		})
+	}
+	return pkgs
+}
+
+// loadKnownPackages lists the packages that can be imported from dir and
+// caches them. Module packages are listed with the go command and GOPATH
+// packages with importPaths.
+func loadKnownPackages(environ map[string]string, installSuffix, dir string) ([]importPackage, error) {
+	ctxt, key := knownPackagesKey(environ, installSuffix, dir)
+	v, err, _ := knownPackagesGroup.Do(key, func() (interface{}, error) {
+		var pkgs []importPackage
+		var first error
+		if _, err := contextutil.ContainingDirectory(ctxt, dir, "", "go.mod"); err == nil {
+			pkgs, first = listModulePackages(ctxt, dir)
+		}
+		seen := make(map[string]bool, len(pkgs))
+		for _, p := range pkgs {
+			seen[p.Path] = true
+		}
+		paths, err := importPaths(environ, installSuffix, dir)
+		if err != nil && first == nil {
+			first = err
+		}
+		pkgs = gopathPackages(pkgs, paths, seen)
+		rankPackages(pkgs)
+		if len(pkgs) != 0 {
+			knownPackagesCache.Add(key, &knownPackagesEntry{
+				Created: time.Now(),
+				Pkgs:    pkgs,
+			})
+		}
+		return pkgs, first
+	})
+	pkgs, _ := v.([]importPackage)
+	return pkgs, err
+}
+
+// knownPackages returns the packages that can be imported from dir ranked by
+// kind: the standard library, the main module, its dependencies and GOPATH.
+//
+// Listing module packages runs the go command so the packages are loaded in
+// the background: stale packages are returned while they are refreshed and
+// on a cold cache only the packages found by importPaths are returned.
+func knownPackages(environ map[string]string, installSuffix, dir string) ([]importPackage, error) {
+	_, key := knownPackagesKey(environ, installSuffix, dir)
+	refresh := func() {
+		// DoChan does not block and only one load per key is in flight
+		knownPackagesGroup.DoChan(key+"|refresh", func() (interface{}, error) {
+			pkgs, err := loadKnownPackages(environ, installSuffix, dir)
+			if err != nil {
+				logger.Named("import_paths").Debug("loading known packages",
+					zap.String("dir", dir), zap.Error(err))
+			}
+			return pkgs, err
+		})
+	}
+	if v, ok := knownPackagesCache.Get(key); ok {
+		e := v.(*knownPackagesEntry)
+		if time.Since(e.Created) >= knownPackagesTTL {
+			refresh()
+		}
+		return e.Pkgs, nil
+	}
+	refresh()
+	paths, err := importPaths(environ, installSuffix, dir)
+	pkgs := gopathPackages(nil, paths, make(map[string]bool, len(paths)))
+	rankPackages(pkgs)
+	return pkgs, err
+}
+
 func init() {
 	registry.Register("import_paths", func(_ *Broker) Caller {
 		return &mImportPaths{
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"go/ast"
 	"go/parser"
 	"go/printer"
 	"go/token"
@@ -31,12 +32,14 @@ type mImportsResponse struct {
 	LineRef int    `json:"lineRef"`
 }
 
-// TODO: replace python patch/merge logic
-func (m *mImports) Call() (interface{}, string) {
+// parseImportDecls parses the package clause and imports of filename and
+// returns the last line of the imports. Comments following the imports are
+// removed so they are not printed.
+func parseImportDecls(filename string, src interface{}) (*token.FileSet, *ast.File, int, error) {
 	fset := token.NewFileSet()
-	af, err := parser.ParseFile(fset, m.Fn, m.Src, parser.ImportsOnly|parser.ParseComments)
+	af, err := parser.ParseFile(fset, filename, src, parser.ImportsOnly|parser.ParseComments)
 	if err != nil {
-		return nil, err.Error()
+		return nil, nil, 0, err
 	}
 
 	// make GoSublime python happy (gspalette.py => gspatch.py).
@@ -53,6 +56,72 @@ func (m *mImports) Call() (interface{}, string) {
 			break
 		}
 	}
+	return fset, af, lineRef, nil
+}
+
+// printImportDecls prints the package clause and imports of af.
+func printImportDecls(filename string, fset *token.FileSet, af *ast.File, tabIndent bool, tabWidth int) ([]byte, error) {
+	mode := printer.UseSpaces
+	if tabIndent {
+		mode |= printer.TabIndent
+	}
+	conf := printer.Config{
+		Mode:     mode,
+		Tabwidth: tabWidth,
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(int(af.End()) + 128)
+	if err := conf.Fprint(&buf, fset, af); err != nil {
+		return nil, err
+	}
+
+	opts := imports.Options{
+		Fragment:   true,
+		AllErrors:  false,
+		Comments:   true,
+		TabIndent:  tabIndent,
+		TabWidth:   tabWidth,
+		FormatOnly: true,
+	}
+	return imports.Process(filename, buf.Bytes(), &opts)
+}
+
+// addImportEdits returns the edits that add an import of path to src, or nil
+// if src already imports it.
+func addImportEdits(filename string, src []byte, path string) ([]TextEdit, error) {
+	fset, af, lineRef, err := parseImportDecls(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	if !astutil.AddImport(fset, af, path) {
+		return nil, nil
+	}
+	out, err := printImportDecls(filename, fset, af, true, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	// the source up to and including the line of the last import
+	end := len(src)
+	for line, i := 1, 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			if line == lineRef {
+				end = i + 1
+				break
+			}
+			line++
+		}
+	}
+	return computeTextEdits(filename, src[:end], out), nil
+}
+
+// TODO: replace python patch/merge logic
+func (m *mImports) Call() (interface{}, string) {
+	fset, af, lineRef, err := parseImportDecls(m.Fn, m.Src)
+	if err != nil {
+		return nil, err.Error()
+	}
 
 	var added []string
 	for _, x := range m.Toggle {
@@ -73,30 +142,7 @@ func (m *mImports) Call() (interface{}, string) {
 		}()
 	}
 
-	mode := printer.UseSpaces
-	if m.TabIndent {
-		mode |= printer.TabIndent
-	}
-	conf := printer.Config{
-		Mode:     mode,
-		Tabwidth: m.TabWidth,
-	}
-
-	var buf bytes.Buffer
-	buf.Grow(int(af.End()) + 128)
-	if err := conf.Fprint(&buf, fset, af); err != nil {
-		return &mImportsResponse{}, err.Error()
-	}
-
-	opts := imports.Options{
-		Fragment:   true,
-		AllErrors:  false,
-		Comments:   true,
-		TabIndent:  m.TabIndent,
-		TabWidth:   m.TabWidth,
-		FormatOnly: true,
-	}
-	src, err := imports.Process(m.Fn, buf.Bytes(), &opts)
+	src, err := printImportDecls(m.Fn, fset, af, m.TabIndent, m.TabWidth)
 	if err != nil {
 		return &mImportsResponse{}, err.Error()
 	}
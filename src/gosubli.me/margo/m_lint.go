@@ -1,8 +1,17 @@
 package main
 
 import (
-	"go/ast"
-	"go/token"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charlievieth/buildutil"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/tools/go/packages"
 )
 
 type mLintReport struct {
@@ -11,86 +20,142 @@ type mLintReport struct {
 	Col     int
 	Message string
 	Kind    string
+	// Severity is one of "error", "warning" or "info".
+	Severity string
+	Fixes    []SuggestedFix `json:",omitempty"`
 }
 
 type mLint struct {
 	Dir JsonString
 	Fn  JsonString
 	Src JsonString
-	v   struct {
-		dir string
-		fn  string
-		src string
-	}
-	Filter []string
 
-	fset    *token.FileSet
-	af      *ast.File
-	reports []mLintReport
+	// Filter is the list of analyzers (report kinds) to skip for this
+	// request only, it is applied after the ".mgo_lint" configuration.
+	Filter []string
 }
 
-// TODO (CEV): This is now a no-op, either fix or remove this code.
-func (m *mLint) Call() (interface{}, string) {
-	return M{"reports": []mLintReport{}}, ""
-}
+const lintLoadMode = packages.NeedName | packages.NeedFiles |
+	packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedTypes |
+	packages.NeedTypesSizes | packages.NeedSyntax | packages.NeedTypesInfo |
+	packages.NeedModule
 
-// var (
-// mLintErrPat = regexp.MustCompile(`(.+?):(\d+):(\d+): (.+)`)
+// loadFilePackage loads the package containing filename. If src is not nil
+// it is used in place of the contents of filename.
+func loadFilePackage(ctxt *build.Context, filename string, src []byte, mode packages.LoadMode) (*packages.Package, error) {
+	filename = filepath.Clean(filename)
+	cfg := &packages.Config{
+		Mode:  mode,
+		Dir:   filepath.Dir(filename),
+		Env:   buildutil.GoCommand(ctxt, "go").Env,
+		Tests: strings.HasSuffix(filename, "_test.go"),
+	}
+	if src != nil {
+		cfg.Overlay = map[string][]byte{filename: src}
+	}
+	pkgs, err := packages.Load(cfg, "file="+filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		// ignore the generated test main package
+		if strings.HasSuffix(pkg.ID, ".test") {
+			continue
+		}
+		for _, name := range pkg.CompiledGoFiles {
+			if name == filename {
+				if pkg.Types == nil && len(pkg.Errors) != 0 {
+					return nil, pkg.Errors[0]
+				}
+				return pkg, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no package found for file: %s", filename)
+}
 
-// TODO (CEV): old code - update GoSubl python libs and remove
-//
-// mLinters    = map[string]func(kind string, m *mLint){
-// 	"gs.flag.parse": mLintCheckFlagParse,
-// 	"gs.types":      mLintCheckTypes,
-// }
-// )
+func (m *mLint) filename() string {
+	fn := m.Fn.String()
+	if !filepath.IsAbs(fn) && m.Dir != "" {
+		fn = filepath.Join(m.Dir.String(), fn)
+	}
+	return filepath.Clean(fn)
+}
 
-/*
-func (m *mLint) Call() (interface{}, string) {
-	const allErrors = false
+func (m *mLint) lint() ([]mLintReport, error) {
+	filename := m.filename()
+	var src []byte
+	if m.Src != "" {
+		src = []byte(m.Src)
+	} else {
+		var err error
+		if src, err = os.ReadFile(filename); err != nil {
+			return nil, err
+		}
+	}
 
-	src := []byte(m.Src.String())
-	filename := m.Fn.String()
+	ctxt := fileBuildContext(filename, src)
+	pkg, err := loadFilePackage(ctxt, filename, src, lintLoadMode)
+	if err != nil {
+		return nil, err
+	}
 
-	var allFiles bool
-	if strings.HasSuffix(filename, "_test.go") {
-		allFiles = true
+	conf := loadLintConfig(filepath.Dir(filename))
+	diags, err := newLintDriver(pkg).Run(conf.Analyzers(m.Filter))
+	if err != nil {
+		// Errors are expected when the package does not type check
+		// so log and report what we have.
+		logger.Named("lint").Debug("analysis error", zap.String("filename", filename),
+			zap.Error(err))
 	}
 
-	context := func() *build.Context {
-		var ctxt = build.Default
-		if p := os.Getenv("GOPATH"); p != "" {
-			ctxt.GOPATH = p
+	var ignores *lintIgnores
+	for _, af := range pkg.Syntax {
+		if pkg.Fset.File(af.Pos()).Name() == filename {
+			ignores = newLintIgnores(pkg.Fset, af, src)
+			break
 		}
-		if p := runtime.GOROOT(); p != "" {
-			ctxt.GOROOT = p
-		}
-		return &ctxt
 	}
 
-	list, err := gotype.Check(context(), filename, src, allFiles, allErrors)
-	if err != nil {
-		return nil, err.Error()
-	}
-	rep := make([]mLintReport, len(list))
-	for i, e := range list {
-		if e.Filename != filename {
+	reports := make([]mLintReport, 0, len(diags))
+	for _, d := range diags {
+		pos := pkg.Fset.Position(d.Pos)
+		if pos.Filename != filename || ignores.Ignored(d.Analyzer.Name, d.Pos) {
 			continue
 		}
-		rep[i] = mLintReport{
-			Fn:      e.Filename,
-			Row:     e.Row - 1,
-			Col:     e.Col - 1,
-			Message: e.Message,
-			Kind:    e.Kind,
-		}
+		reports = append(reports, mLintReport{
+			Fn:       pos.Filename,
+			Row:      pos.Line - 1,
+			Col:      pos.Column - 1,
+			Message:  d.Message,
+			Kind:     d.Analyzer.Name,
+			Severity: conf.Severity(d.Analyzer.Name),
+			Fixes:    analysisFixes(pkg.Fset, filename, src, d.SuggestedFixes),
+		})
+	}
+	return reports, nil
+}
+
+var lintGroup singleflight.Group
+
+func (m *mLint) Call() (interface{}, string) {
+	if m.Fn == "" {
+		return M{"reports": []mLintReport{}}, "lint: missing filename"
 	}
-	res := M{
-		"reports": rep,
+	key := m.filename() + "|" + fileCacheKey(m.filename(), m.Src.String()) + "|" +
+		strings.Join(m.Filter, ",")
+	start := time.Now()
+	v, err, _ := lintGroup.Do(key, func() (interface{}, error) {
+		return m.lint()
+	})
+	reports, _ := v.([]mLintReport)
+	if reports == nil {
+		reports = []mLintReport{}
 	}
-	return res, ""
+	logger.Named("lint").Debug("lint", zap.String("filename", m.filename()),
+		zap.Int("reports", len(reports)), zap.Duration("duration", time.Since(start)))
+	return M{"reports": reports}, errStr(err)
 }
-*/
 
 func init() {
 	registry.Register("lint", func(_ *Broker) Caller {
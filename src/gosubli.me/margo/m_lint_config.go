@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/atomicalign"
+	"golang.org/x/tools/go/analysis/passes/deepequalerrors"
+	"golang.org/x/tools/go/analysis/passes/fieldalignment"
+	"golang.org/x/tools/go/analysis/passes/reflectvaluecompare"
+	"golang.org/x/tools/go/analysis/passes/sortslice"
+	"golang.org/x/tools/go/analysis/passes/unusedwrite"
+)
+
+// lintOptionalAnalyzers are analyzers that are disabled by default and may
+// be enabled in a ".mgo_lint" file.
+var lintOptionalAnalyzers = []*analysis.Analyzer{
+	atomicalign.Analyzer,
+	deepequalerrors.Analyzer,
+	fieldalignment.Analyzer,
+	reflectvaluecompare.Analyzer,
+	sortslice.Analyzer,
+	unusedwrite.Analyzer,
+}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+const lintConfigName = ".mgo_lint"
+
+// lintConfig is the merged configuration of the ".mgo_lint" files that apply
+// to a directory. Each file contains one directive per line, lines starting
+// with '#' are comments:
+//
+//	enable fieldalignment unusedwrite
+//	disable shadow
+//	severity printf error
+//
+// Files closer to the linted file take precedence over those in parent
+// directories, the search stops at the project root (see isRoot).
+type lintConfig struct {
+	enabled  map[string]bool   // analyzer => enabled
+	severity map[string]string // analyzer => severity
+}
+
+func validSeverity(s string) bool {
+	switch s {
+	case SeverityError, SeverityWarning, SeverityInfo:
+		return true
+	}
+	return false
+}
+
+func lintConfigFiles(dir string) []string {
+	var names []string
+	for d := filepath.Clean(dir); ; {
+		name := filepath.Join(d, lintConfigName)
+		if fileExists(name) {
+			names = append(names, name)
+		}
+		next := filepath.Dir(d)
+		if isRoot(d) || next == d {
+			break
+		}
+		d = next
+	}
+	return names
+}
+
+// loadLintConfig loads the lint configuration for directory dir.
+func loadLintConfig(dir string) *lintConfig {
+	c := &lintConfig{
+		enabled:  make(map[string]bool),
+		severity: make(map[string]string),
+	}
+	names := lintConfigFiles(dir)
+	// apply the outermost config first
+	for i := len(names) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(names[i])
+		if err == nil {
+			err = c.parse(names[i], data)
+		}
+		if err != nil {
+			logger.Named("lint").Warn("error reading lint config",
+				zap.String("filename", names[i]), zap.Error(err))
+		}
+	}
+	return c
+}
+
+func (c *lintConfig) parse(filename string, data []byte) error {
+	var errs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "enable", "disable":
+			for _, name := range fields[1:] {
+				c.enabled[name] = fields[0] == "enable"
+			}
+		case "severity":
+			if len(fields) != 3 || !validSeverity(fields[2]) {
+				errs = append(errs, fmt.Sprintf("%s:%d: invalid severity directive: %q",
+					filename, lineno, line))
+				continue
+			}
+			c.severity[fields[1]] = fields[2]
+		default:
+			errs = append(errs, fmt.Sprintf("%s:%d: invalid directive: %q",
+				filename, lineno, fields[0]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Analyzers returns the enabled analyzers less those in filter.
+func (c *lintConfig) Analyzers(filter []string) []*analysis.Analyzer {
+	skip := make(map[string]bool, len(filter))
+	for _, s := range filter {
+		skip[s] = true
+	}
+	var a []*analysis.Analyzer
+	for _, x := range lintAnalyzers {
+		if enabled, ok := c.enabled[x.Name]; (!ok || enabled) && !skip[x.Name] {
+			a = append(a, x)
+		}
+	}
+	for _, x := range lintOptionalAnalyzers {
+		if c.enabled[x.Name] && !skip[x.Name] {
+			a = append(a, x)
+		}
+	}
+	return a
+}
+
+// Severity returns the severity of reports from analyzer name.
+func (c *lintConfig) Severity(name string) string {
+	if s := c.severity[name]; s != "" {
+		return s
+	}
+	return SeverityWarning
+}
+
+const lintIgnoreDirective = "//margo:ignore"
+
+// lintIgnore is a "//margo:ignore" directive.
+type lintIgnore struct {
+	analyzers map[string]bool // nil means all analyzers
+	pos, end  token.Pos       // declaration range, if any
+	line      int             // line the directive applies to
+}
+
+func (x *lintIgnore) matches(analyzer string) bool {
+	return x.analyzers == nil || x.analyzers[analyzer]
+}
+
+// lintIgnores holds the "//margo:ignore" directives of a file.
+//
+// A directive has the form "//margo:ignore [analyzer[,analyzer...]] [reason]"
+// and if no analyzers are listed it applies to all of them. A directive in
+// the doc comment of a declaration applies to the whole declaration, one on
+// a line by itself applies to the next line and a trailing directive applies
+// to its own line.
+type lintIgnores struct {
+	fset    *token.FileSet
+	ignores []lintIgnore
+}
+
+func parseLintIgnore(text string) (map[string]bool, bool) {
+	if !strings.HasPrefix(text, lintIgnoreDirective) {
+		return nil, false
+	}
+	rest := text[len(lintIgnoreDirective):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return nil, false // "//margo:ignoreXYZ"
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || fields[0] == "all" {
+		return nil, true
+	}
+	names := make(map[string]bool)
+	for _, s := range strings.Split(fields[0], ",") {
+		if s != "" {
+			names[s] = true
+		}
+	}
+	return names, true
+}
+
+func newLintIgnores(fset *token.FileSet, af *ast.File, src []byte) *lintIgnores {
+	li := &lintIgnores{fset: fset}
+
+	// map doc comments to the range of their declaration
+	type span struct{ pos, end token.Pos }
+	docs := make(map[*ast.CommentGroup]span)
+	for _, decl := range af.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				docs[d.Doc] = span{d.Pos(), d.End()}
+			}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				docs[d.Doc] = span{d.Pos(), d.End()}
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Doc != nil {
+						docs[s.Doc] = span{s.Pos(), s.End()}
+					}
+				case *ast.ValueSpec:
+					if s.Doc != nil {
+						docs[s.Doc] = span{s.Pos(), s.End()}
+					}
+				}
+			}
+		}
+	}
+
+	tf := fset.File(af.Pos())
+	for _, cg := range af.Comments {
+		for _, c := range cg.List {
+			names, ok := parseLintIgnore(c.Text)
+			if !ok {
+				continue
+			}
+			if sp, ok := docs[cg]; ok {
+				li.ignores = append(li.ignores, lintIgnore{
+					analyzers: names,
+					pos:       sp.pos,
+					end:       sp.end,
+				})
+				continue
+			}
+			line := fset.Position(c.Pos()).Line
+			if tf != nil && lineIsBlankBefore(src, tf.Offset(c.Pos())) {
+				line++ // directive on its own line applies to the next line
+			}
+			li.ignores = append(li.ignores, lintIgnore{analyzers: names, line: line})
+		}
+	}
+	return li
+}
+
+// lineIsBlankBefore returns if src only contains whitespace between the start
+// of the line and offset off.
+func lineIsBlankBefore(src []byte, off int) bool {
+	if off > len(src) {
+		return false
+	}
+	start := bytes.LastIndexByte(src[:off], '\n') + 1
+	return len(bytes.TrimSpace(src[start:off])) == 0
+}
+
+// Ignored returns if the diagnostic reported by analyzer at pos is
+// suppressed by a "//margo:ignore" directive.
+func (li *lintIgnores) Ignored(analyzer string, pos token.Pos) bool {
+	if li == nil || len(li.ignores) == 0 {
+		return false
+	}
+	line := li.fset.Position(pos).Line
+	for i := range li.ignores {
+		x := &li.ignores[i]
+		if !x.matches(analyzer) {
+			continue
+		}
+		if x.pos.IsValid() {
+			if x.pos <= pos && pos < x.end {
+				return true
+			}
+		} else if x.line == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/types"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/appends"
+	"golang.org/x/tools/go/analysis/passes/asmdecl"
+	"golang.org/x/tools/go/analysis/passes/assign"
+	"golang.org/x/tools/go/analysis/passes/atomic"
+	"golang.org/x/tools/go/analysis/passes/bools"
+	"golang.org/x/tools/go/analysis/passes/buildtag"
+	"golang.org/x/tools/go/analysis/passes/cgocall"
+	"golang.org/x/tools/go/analysis/passes/composite"
+	"golang.org/x/tools/go/analysis/passes/copylock"
+	"golang.org/x/tools/go/analysis/passes/defers"
+	"golang.org/x/tools/go/analysis/passes/directive"
+	"golang.org/x/tools/go/analysis/passes/errorsas"
+	"golang.org/x/tools/go/analysis/passes/framepointer"
+	"golang.org/x/tools/go/analysis/passes/httpresponse"
+	"golang.org/x/tools/go/analysis/passes/ifaceassert"
+	"golang.org/x/tools/go/analysis/passes/loopclosure"
+	"golang.org/x/tools/go/analysis/passes/lostcancel"
+	"golang.org/x/tools/go/analysis/passes/nilfunc"
+	"golang.org/x/tools/go/analysis/passes/nilness"
+	"golang.org/x/tools/go/analysis/passes/printf"
+	"golang.org/x/tools/go/analysis/passes/shadow"
+	"golang.org/x/tools/go/analysis/passes/shift"
+	"golang.org/x/tools/go/analysis/passes/sigchanyzer"
+	"golang.org/x/tools/go/analysis/passes/slog"
+	"golang.org/x/tools/go/analysis/passes/stdmethods"
+	"golang.org/x/tools/go/analysis/passes/stringintconv"
+	"golang.org/x/tools/go/analysis/passes/structtag"
+	"golang.org/x/tools/go/analysis/passes/testinggoroutine"
+	"golang.org/x/tools/go/analysis/passes/tests"
+	"golang.org/x/tools/go/analysis/passes/timeformat"
+	"golang.org/x/tools/go/analysis/passes/unmarshal"
+	"golang.org/x/tools/go/analysis/passes/unreachable"
+	"golang.org/x/tools/go/analysis/passes/unsafeptr"
+	"golang.org/x/tools/go/analysis/passes/unusedresult"
+	"golang.org/x/tools/go/packages"
+)
+
+// lintAnalyzers are the analyzers run by the lint method: the go vet suite
+// plus nilness and shadow.
+var lintAnalyzers = []*analysis.Analyzer{
+	appends.Analyzer,
+	asmdecl.Analyzer,
+	assign.Analyzer,
+	atomic.Analyzer,
+	bools.Analyzer,
+	buildtag.Analyzer,
+	cgocall.Analyzer,
+	composite.Analyzer,
+	copylock.Analyzer,
+	defers.Analyzer,
+	directive.Analyzer,
+	errorsas.Analyzer,
+	framepointer.Analyzer,
+	httpresponse.Analyzer,
+	ifaceassert.Analyzer,
+	loopclosure.Analyzer,
+	lostcancel.Analyzer,
+	nilfunc.Analyzer,
+	nilness.Analyzer,
+	printf.Analyzer,
+	shadow.Analyzer,
+	shift.Analyzer,
+	sigchanyzer.Analyzer,
+	slog.Analyzer,
+	stdmethods.Analyzer,
+	stringintconv.Analyzer,
+	structtag.Analyzer,
+	testinggoroutine.Analyzer,
+	tests.Analyzer,
+	timeformat.Analyzer,
+	unmarshal.Analyzer,
+	unreachable.Analyzer,
+	unsafeptr.Analyzer,
+	unusedresult.Analyzer,
+}
+
+var validateLintAnalyzersOnce sync.Once
+
+// lintDiagnostic is an analysis.Diagnostic and the analyzer that reported it.
+type lintDiagnostic struct {
+	analysis.Diagnostic
+	Analyzer *analysis.Analyzer
+}
+
+type lintFactKey struct {
+	obj types.Object   // nil for package facts
+	pkg *types.Package // nil for object facts
+	typ reflect.Type
+}
+
+// lintDriver runs analyzers on a single package loaded with go/packages.
+//
+// Facts are only tracked within the package: facts exported by dependencies
+// are not available since we do not analyze them.
+type lintDriver struct {
+	pkg     *packages.Package
+	actions map[*analysis.Analyzer]*lintAction
+	facts   map[lintFactKey]analysis.Fact
+	diags   []lintDiagnostic
+}
+
+type lintAction struct {
+	result interface{}
+	err    error
+}
+
+func newLintDriver(pkg *packages.Package) *lintDriver {
+	return &lintDriver{
+		pkg:     pkg,
+		actions: make(map[*analysis.Analyzer]*lintAction),
+		facts:   make(map[lintFactKey]analysis.Fact),
+	}
+}
+
+// Run runs analyzers (and their requirements) on the package and returns the
+// reported diagnostics.
+func (d *lintDriver) Run(analyzers []*analysis.Analyzer) ([]lintDiagnostic, error) {
+	validateLintAnalyzersOnce.Do(func() {
+		all := append(lintAnalyzers[:len(lintAnalyzers):len(lintAnalyzers)],
+			lintOptionalAnalyzers...)
+		if err := analysis.Validate(all); err != nil {
+			panic(err)
+		}
+	})
+	var errs []error
+	for _, a := range analyzers {
+		if act := d.run(a); act.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Name, act.err))
+		}
+	}
+	sort.SliceStable(d.diags, func(i, j int) bool {
+		return d.diags[i].Pos < d.diags[j].Pos
+	})
+	return d.diags, errors.Join(errs...)
+}
+
+func (d *lintDriver) run(a *analysis.Analyzer) *lintAction {
+	if act, ok := d.actions[a]; ok {
+		return act
+	}
+	act := new(lintAction)
+	d.actions[a] = act
+
+	pkg := d.pkg
+	if pkg.IllTyped && !a.RunDespiteErrors {
+		act.err = fmt.Errorf("skipped due to errors in package %s", pkg.PkgPath)
+		return act
+	}
+
+	resultOf := make(map[*analysis.Analyzer]interface{}, len(a.Requires))
+	for _, req := range a.Requires {
+		ra := d.run(req)
+		if ra.err != nil {
+			act.err = fmt.Errorf("failed prerequisite %s: %w", req.Name, ra.err)
+			return act
+		}
+		resultOf[req] = ra.result
+	}
+
+	var module *analysis.Module
+	if m := pkg.Module; m != nil {
+		module = &analysis.Module{Path: m.Path, Version: m.Version, GoVersion: m.GoVersion}
+	}
+
+	pass := &analysis.Pass{
+		Analyzer:     a,
+		Fset:         pkg.Fset,
+		Files:        pkg.Syntax,
+		OtherFiles:   pkg.OtherFiles,
+		IgnoredFiles: pkg.IgnoredFiles,
+		Pkg:          pkg.Types,
+		TypesInfo:    pkg.TypesInfo,
+		TypesSizes:   pkg.TypesSizes,
+		TypeErrors:   pkg.TypeErrors,
+		Module:       module,
+		ResultOf:     resultOf,
+		Report: func(diag analysis.Diagnostic) {
+			d.diags = append(d.diags, lintDiagnostic{Diagnostic: diag, Analyzer: a})
+		},
+		ReadFile: os.ReadFile,
+		ImportObjectFact: func(obj types.Object, fact analysis.Fact) bool {
+			return d.importFact(lintFactKey{obj: obj, typ: reflect.TypeOf(fact)}, fact)
+		},
+		ImportPackageFact: func(p *types.Package, fact analysis.Fact) bool {
+			return d.importFact(lintFactKey{pkg: p, typ: reflect.TypeOf(fact)}, fact)
+		},
+		ExportObjectFact: func(obj types.Object, fact analysis.Fact) {
+			d.facts[lintFactKey{obj: obj, typ: reflect.TypeOf(fact)}] = fact
+		},
+		ExportPackageFact: func(fact analysis.Fact) {
+			d.facts[lintFactKey{pkg: pkg.Types, typ: reflect.TypeOf(fact)}] = fact
+		},
+		AllObjectFacts: func() []analysis.ObjectFact {
+			var facts []analysis.ObjectFact
+			for k, f := range d.facts {
+				if k.obj != nil {
+					facts = append(facts, analysis.ObjectFact{Object: k.obj, Fact: f})
+				}
+			}
+			return facts
+		},
+		AllPackageFacts: func() []analysis.PackageFact {
+			var facts []analysis.PackageFact
+			for k, f := range d.facts {
+				if k.pkg != nil {
+					facts = append(facts, analysis.PackageFact{Package: k.pkg, Fact: f})
+				}
+			}
+			return facts
+		},
+	}
+
+	func() {
+		defer func() {
+			if e := recover(); e != nil {
+				act.err = fmt.Errorf("analyzer panic: %v", e)
+			}
+		}()
+		act.result, act.err = a.Run(pass)
+	}()
+	return act
+}
+
+// importFact copies the fact stored at key into ptr.
+func (d *lintDriver) importFact(key lintFactKey, ptr analysis.Fact) bool {
+	fact, ok := d.facts[key]
+	if !ok {
+		return false
+	}
+	reflect.ValueOf(ptr).Elem().Set(reflect.ValueOf(fact).Elem())
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if _, ok := files["go.mod"]; !ok {
+		files["go.mod"] = "module example.com/lint\n\ngo 1.22\n"
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLint(t *testing.T) {
+	const src = `package lint
+
+import "fmt"
+
+func Hello(name string) string {
+	return fmt.Sprintf("hello %d", name)
+}
+`
+	dir := writeTestModule(t, map[string]string{"lint.go": "package lint\n"})
+	filename := filepath.Join(dir, "lint.go")
+
+	// the unsaved source should be linted and not the file on disk
+	m := &mLint{Fn: JsonString(filename), Src: JsonString(src)}
+	reports, err := m.lint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report got: %+v", reports)
+	}
+	r := reports[0]
+	if r.Kind != "printf" || r.Row != 5 || r.Fn != filename {
+		t.Errorf("unexpected report: %+v", r)
+	}
+
+	m.Filter = []string{"printf"}
+	reports, err = m.lint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("expected filtered reports to be empty got: %+v", reports)
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	const src = `package lint
+
+import "fmt"
+
+func Hello(name string) string {
+	return fmt.Sprintf("hello %d", name)
+}
+`
+	dir := writeTestModule(t, map[string]string{
+		".mgo_lint":     "severity printf error\n",
+		"sub/.mgo_lint": "# sub package\ndisable printf\n",
+		"lint.go":       src,
+		"sub/sub.go":    "package sub\n\n" + src[len("package lint\n\n"):],
+	})
+
+	m := &mLint{Fn: JsonString(filepath.Join(dir, "lint.go"))}
+	reports, err := m.lint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Severity != SeverityError {
+		t.Errorf("expected 1 printf error got: %+v", reports)
+	}
+
+	m = &mLint{Fn: JsonString(filepath.Join(dir, "sub", "sub.go"))}
+	reports, err = m.lint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("expected printf to be disabled got: %+v", reports)
+	}
+}
+
+func TestLintIgnore(t *testing.T) {
+	const src = `package lint
+
+import "fmt"
+
+//margo:ignore printf
+func A(name string) string {
+	return fmt.Sprintf("a %d", name)
+}
+
+func B(name string) string {
+	return fmt.Sprintf("b %d", name) //margo:ignore printf,shadow
+}
+
+func C(name string) string {
+	//margo:ignore
+	return fmt.Sprintf("c %d", name)
+}
+
+func D(name string) string {
+	//margo:ignore shadow
+	return fmt.Sprintf("d %d", name)
+}
+`
+	dir := writeTestModule(t, map[string]string{"lint.go": src})
+	m := &mLint{Fn: JsonString(filepath.Join(dir, "lint.go"))}
+	reports, err := m.lint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Row != 20 {
+		t.Errorf("expected 1 report for D got: %+v", reports)
+	}
+}
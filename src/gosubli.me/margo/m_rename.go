@@ -61,20 +61,8 @@ func (r *RenameRequest) Call() (interface{}, string) {
 		return nil, errStr(ErrGoplsNotInstalled)
 	}
 
-	parent := build.Default
-	if r.Env != nil {
-		if s, ok := r.Env["GOPATH"]; ok && isDir(s) {
-			parent.GOPATH = s
-		}
-		if s, ok := r.Env["GOROOT"]; ok && isDir(s) {
-			parent.GOROOT = s
-		}
-		if s := r.Env["GOOS"]; s != "" {
-			parent.GOOS = s
-		}
-	}
-
-	ctxt, err := buildutil.MatchContext(&parent, r.Filename, nil)
+	parent := contextFromEnv(r.Env, r.Filename)
+	ctxt, err := buildutil.MatchContext(parent, r.Filename, nil)
 	if err != nil {
 		return "", errStr(err)
 	}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charlievieth/buildutil"
+	"go.uber.org/zap"
+	"golang.org/x/tools/go/packages"
+	"gosubli.me/margo/internal/lru"
+)
+
+// The in-process type checker loads the package graph once with go/packages
+// and type checks the current package from source. Dependencies are type
+// checked without function bodies and cached, with the graph, by a hash of
+// their file contents and the hashes of their own dependencies, so only
+// packages whose sources (or dependencies) changed are checked again.
+
+type fileHash [sha256.Size]byte
+
+type fileHashEntry struct {
+	size  int64
+	mtime time.Time
+	hash  fileHash
+}
+
+// fileHashes caches the content hash of files by size and modification time.
+var fileHashes = struct {
+	sync.Mutex
+	m map[string]fileHashEntry
+}{m: make(map[string]fileHashEntry)}
+
+func hashFile(name string) (fileHash, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileHash{}, err
+	}
+	fileHashes.Lock()
+	e, ok := fileHashes.m[name]
+	fileHashes.Unlock()
+	if ok && e.size == fi.Size() && e.mtime.Equal(fi.ModTime()) {
+		return e.hash, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fileHash{}, err
+	}
+	e = fileHashEntry{size: fi.Size(), mtime: fi.ModTime(), hash: sha256.Sum256(data)}
+	fileHashes.Lock()
+	fileHashes.m[name] = e
+	fileHashes.Unlock()
+	return e.hash, nil
+}
+
+// tcPackage is the metadata of a package in the graph.
+type tcPackage struct {
+	id       string
+	pkgPath  string
+	files    []string          // compiled Go files
+	imports  map[string]string // import path => package ID
+	dir      string
+	dirFiles []string // Go files in dir when loaded
+}
+
+// tcGraph is the package graph of a root package.
+type tcGraph struct {
+	root      string // ID of the root package
+	pkgs      map[string]*tcPackage
+	dirFiles  []string // Go files in the root directory when loaded
+	goVersion string
+	cgo       bool // root package uses cgo
+
+	// mu serializes type checking since the dependencies are shared.
+	mu sync.Mutex
+	// fset contains the files of the cached dependencies so that the
+	// positions of imported objects are valid.
+	fset *token.FileSet
+	// fsetBase is the size of fset after the first check, fset and the
+	// cache are rebuilt once changed dependencies double it.
+	fsetBase int
+	// checked caches the type checked dependencies by package hash.
+	checked map[string]*types.Package
+}
+
+// typeCheckGraphs caches package graphs by build context, directory and
+// whether the tests of the package are included.
+var typeCheckGraphs = lru.New(64)
+
+// contextKey returns a key that identifies the build configuration of ctxt.
+func contextKey(ctxt *build.Context) string {
+	return strings.Join([]string{
+		ctxt.GOROOT, ctxt.GOPATH, ctxt.GOOS, ctxt.GOARCH,
+		strconv.FormatBool(ctxt.CgoEnabled), strings.Join(ctxt.BuildTags, ","),
+	}, "|")
+}
+
+func goFilesInDir(dir string) []string {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, d := range des {
+		if name := d.Name(); strings.HasSuffix(name, ".go") && !d.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func loadTypeCheckGraph(ctx context.Context, ctxt *build.Context, filename string, overlay map[string][]byte) (*tcGraph, error) {
+	const mode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+		packages.NeedImports | packages.NeedDeps | packages.NeedModule
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    mode,
+		Dir:     filepath.Dir(filename),
+		Env:     buildutil.GoCommand(ctxt, "go").Env,
+		Tests:   strings.HasSuffix(filename, "_test.go"),
+		Overlay: overlay,
+	}
+	pkgs, err := packages.Load(cfg, "file="+filename)
+	if err != nil {
+		return nil, err
+	}
+	g := &tcGraph{
+		pkgs:     make(map[string]*tcPackage),
+		dirFiles: goFilesInDir(filepath.Dir(filename)),
+		fset:     token.NewFileSet(),
+		checked:  make(map[string]*types.Package),
+	}
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		tp := &tcPackage{
+			id:      p.ID,
+			pkgPath: p.PkgPath,
+			files:   p.CompiledGoFiles,
+			imports: make(map[string]string, len(p.Imports)),
+		}
+		// GoFiles, unlike the compiled files of cgo packages, are in the
+		// package directory
+		if len(p.GoFiles) != 0 {
+			tp.dir = filepath.Dir(p.GoFiles[0])
+			tp.dirFiles = goFilesInDir(tp.dir)
+		}
+		for path, imp := range p.Imports {
+			tp.imports[path] = imp.ID
+		}
+		g.pkgs[p.ID] = tp
+	})
+	for _, p := range pkgs {
+		if strings.HasSuffix(p.ID, ".test") {
+			continue
+		}
+		for _, name := range p.GoFiles {
+			if name != filename {
+				continue
+			}
+			g.root = p.ID
+			if p.Module != nil && p.Module.GoVersion != "" {
+				g.goVersion = "go" + p.Module.GoVersion
+			}
+			_, g.cgo = p.Imports["C"]
+			if len(p.CompiledGoFiles) != len(p.GoFiles) {
+				g.cgo = true
+			}
+			g.pkgs[p.ID].files = p.GoFiles
+		}
+	}
+	if g.root == "" {
+		return nil, fmt.Errorf("no package found for file: %s", filename)
+	}
+	return g, nil
+}
+
+// fileImportPaths returns the sorted import paths of files.
+func fileImportPaths(files []*ast.File) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, f := range files {
+		for _, imp := range f.Imports {
+			path, err := strconv.Unquote(imp.Path.Value)
+			if err == nil && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (p *tcPackage) importPaths() []string {
+	paths := make([]string, 0, len(p.imports))
+	for path := range p.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// errGraphChanged is returned when the imports of a package no longer match
+// the cached graph.
+var errGraphChanged = errors.New("typecheck: package graph changed")
+
+// typeChecker type checks the packages of a graph.
+type typeChecker struct {
+	ctxt    *build.Context
+	key     string            // contextKey
+	overlay map[string][]byte // unsaved files
+	graph   *tcGraph
+	sizes   types.Sizes
+	hashes  map[string]string // package ID => hash
+	pkgs    map[string]*types.Package
+	checked map[string]*types.Package // package hash => dependency
+}
+
+// hashFile returns the hash of name using the unsaved contents if any.
+func (tc *typeChecker) hashFile(name string) (fileHash, error) {
+	if src, ok := tc.overlay[name]; ok {
+		return sha256.Sum256(src), nil
+	}
+	return hashFile(name)
+}
+
+func (tc *typeChecker) parseFiles(names []string) ([]*ast.File, error) {
+	files := make([]*ast.File, 0, len(names))
+	for _, name := range names {
+		var src interface{}
+		if data, ok := tc.overlay[name]; ok {
+			src = data
+		}
+		f, err := parser.ParseFile(tc.graph.fset, name, src, parser.SkipObjectResolution)
+		if f == nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// packageHash returns the hash of package id which changes if its files or
+// any of its dependencies change. If files were added to or removed from the
+// directory of the package errGraphChanged is returned.
+func (tc *typeChecker) packageHash(id string) (string, error) {
+	if h, ok := tc.hashes[id]; ok {
+		return h, nil
+	}
+	p := tc.graph.pkgs[id]
+	if p.dir != "" && !equalStrings(p.dirFiles, goFilesInDir(p.dir)) {
+		return "", errGraphChanged
+	}
+	hash := sha256.New()
+	hash.Write([]byte(tc.key + "|" + id))
+	for _, name := range p.files {
+		h, err := tc.hashFile(name)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(h[:])
+	}
+	for _, path := range p.importPaths() {
+		h, err := tc.packageHash(p.imports[path])
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(h))
+	}
+	s := hex.EncodeToString(hash.Sum(nil))
+	tc.hashes[id] = s
+	return s, nil
+}
+
+// importerFunc implements types.Importer.
+type importerFunc func(path string) (*types.Package, error)
+
+func (fn importerFunc) Import(path string) (*types.Package, error) { return fn(path) }
+
+// importer returns a types.Importer for the imports of package p.
+func (tc *typeChecker) importer(p *tcPackage) types.Importer {
+	return importerFunc(func(path string) (*types.Package, error) {
+		if path == "unsafe" {
+			return types.Unsafe, nil
+		}
+		id, ok := p.imports[path]
+		if !ok {
+			return nil, fmt.Errorf("package %q not found in the imports of %q", path, p.pkgPath)
+		}
+		return tc.dependency(id)
+	})
+}
+
+// dependency returns the type checked dependency id, checking it if it is not
+// cached.
+func (tc *typeChecker) dependency(id string) (*types.Package, error) {
+	if pkg, ok := tc.pkgs[id]; ok {
+		return pkg, nil
+	}
+	p := tc.graph.pkgs[id]
+	if p == nil {
+		return nil, fmt.Errorf("missing package: %q", id)
+	}
+	hash, err := tc.packageHash(id)
+	if err != nil {
+		return nil, err
+	}
+	if pkg, ok := tc.graph.checked[hash]; ok {
+		tc.pkgs[id] = pkg
+		tc.checked[hash] = pkg
+		return pkg, nil
+	}
+
+	files, err := tc.parseFiles(p.files)
+	if err != nil {
+		return nil, err
+	}
+	if !equalStrings(fileImportPaths(files), p.importPaths()) {
+		return nil, errGraphChanged
+	}
+	conf := types.Config{
+		IgnoreFuncBodies: true,
+		Importer:         tc.importer(p),
+		Sizes:            tc.sizes,
+		// Report errors in the package being edited, not its
+		// dependencies.
+		Error: func(error) {},
+	}
+	pkg, _ := conf.Check(p.pkgPath, tc.graph.fset, files, nil)
+	tc.pkgs[id] = pkg
+	tc.checked[hash] = pkg
+	return pkg, nil
+}
+
+// checkRoot type checks the root package and returns the type errors.
+func (tc *typeChecker) checkRoot() ([]CompileError, error) {
+	p := tc.graph.pkgs[tc.graph.root]
+	fset := token.NewFileSet()
+	// Parse errors are reported by the type checker.
+	var errs []CompileError
+	files := make([]*ast.File, 0, len(p.files))
+	for _, name := range p.files {
+		var src interface{}
+		if data, ok := tc.overlay[name]; ok {
+			src = data
+		}
+		f, err := parser.ParseFile(fset, name, src, parser.AllErrors|parser.SkipObjectResolution)
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				errs = append(errs, CompileError{
+					Row:      e.Pos.Line,
+					Col:      e.Pos.Column,
+					File:     e.Pos.Filename,
+					Message:  e.Msg,
+					Category: CategoryCompile,
+				})
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	if len(errs) != 0 {
+		return errs, nil
+	}
+	if !equalStrings(fileImportPaths(files), p.importPaths()) {
+		return nil, errGraphChanged
+	}
+
+	var importErr error
+	imp := tc.importer(p)
+	conf := types.Config{
+		GoVersion: tc.graph.goVersion,
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			pkg, err := imp.Import(path)
+			if errors.Is(err, errGraphChanged) {
+				importErr = err
+			}
+			return pkg, err
+		}),
+		Sizes: tc.sizes,
+		Error: func(err error) {
+			if e, ok := err.(types.Error); ok {
+				pos := e.Fset.Position(e.Pos)
+				errs = append(errs, CompileError{
+					Row:      pos.Line,
+					Col:      pos.Column,
+					File:     pos.Filename,
+					Message:  e.Msg,
+					Category: CategoryCompile,
+				})
+			}
+		},
+	}
+	conf.Check(p.pkgPath, fset, files, nil)
+	if importErr != nil {
+		return nil, importErr
+	}
+	return errs, nil
+}
+
+// check type checks the root package of g. Only the dependencies used by the
+// check are kept in the cache.
+func (g *tcGraph) check(ctxt *build.Context, overlay map[string][]byte) ([]CompileError, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.fsetBase != 0 && g.fset.Base() > 2*g.fsetBase {
+		g.fset = token.NewFileSet()
+		g.checked = make(map[string]*types.Package)
+		g.fsetBase = 0
+	}
+	tc := &typeChecker{
+		ctxt:    ctxt,
+		key:     contextKey(ctxt),
+		overlay: overlay,
+		graph:   g,
+		sizes:   types.SizesFor("gc", ctxt.GOARCH),
+		hashes:  make(map[string]string),
+		pkgs:    make(map[string]*types.Package),
+		checked: make(map[string]*types.Package),
+	}
+	errs, err := tc.checkRoot()
+	if err == nil {
+		g.checked = tc.checked
+		if g.fsetBase == 0 {
+			g.fsetBase = g.fset.Base()
+		}
+	}
+	return errs, err
+}
+
+// hasRootFile reports if filename is a file of the root package of g.
+func (g *tcGraph) hasRootFile(filename string) bool {
+	for _, name := range g.pkgs[g.root].files {
+		if name == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// typeCheckFile type checks the package containing filename. Files in
+// overlay are unsaved and their contents are used in place of the files on
+// disk.
+func typeCheckFile(ctx context.Context, ctxt *build.Context, filename string, overlay map[string][]byte) ([]CompileError, *tcGraph, error) {
+	// the files of a package share its graph
+	isTest := strings.HasSuffix(filename, "_test.go")
+	graphKey := contextKey(ctxt) + "|" + filepath.Dir(filename) + "|" + strconv.FormatBool(isTest)
+	var g *tcGraph
+	if v, ok := typeCheckGraphs.Get(graphKey); ok {
+		g = v.(*tcGraph)
+		// reload if files were added or removed or filename is not part
+		// of the package (e.g. it is excluded by build constraints)
+		if !equalStrings(g.dirFiles, goFilesInDir(filepath.Dir(filename))) || !g.hasRootFile(filename) {
+			g = nil
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if g == nil {
+			var err error
+			if g, err = loadTypeCheckGraph(ctx, ctxt, filename, overlay); err != nil {
+				return nil, nil, err
+			}
+			typeCheckGraphs.Add(graphKey, g)
+		}
+		if g.cgo {
+			return nil, g, nil
+		}
+		errs, err := g.check(ctxt, overlay)
+		if errors.Is(err, errGraphChanged) && attempt == 0 {
+			g = nil
+			continue
+		}
+		return errs, g, err
+	}
+}
+
+// checkTypes type checks the package in-process and returns the report or nil
+// if the package must be built to report all errors.
+func (c *CompLintRequest) checkTypes(ctx context.Context, ctxt *build.Context, src []byte) *CompLintReport {
+	start := time.Now()
+	overlay := c.overlayFiles(src)
+	if _, ok := overlay[c.Filename]; !ok {
+		overlay[c.Filename] = src
+	}
+	errs, g, err := typeCheckFile(ctx, ctxt, c.Filename, overlay)
+	log := logger.Named("comp_lint")
+	if err != nil {
+		log.Warn("type check failed: falling back to build",
+			zap.String("filename", c.Filename), zap.Error(err))
+		return nil
+	}
+	if g.cgo {
+		log.Debug("type check: package uses cgo: falling back to build",
+			zap.String("filename", c.Filename))
+		return nil
+	}
+	if len(errs) == 0 {
+		if name, _ := buildutil.ReadPackageName(c.Filename, src); name == "main" {
+			return nil // link errors are only reported by the build
+		}
+	}
+	log.Debug("type check", zap.String("filename", c.Filename),
+		zap.Int("errors", len(errs)), zap.Duration("duration", time.Since(start)))
+	r := &CompLintReport{Filename: c.Filename, Errors: errs}
+	if len(errs) != 0 {
+		r.CmdError = "type check failed"
+		addImportFixes(c.Filename, src, r.Errors)
+	}
+	return r
+}
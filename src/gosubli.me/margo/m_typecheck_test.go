@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gosubli.me/margo/internal/lru"
+)
+
+func TestTypeCheckFile(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a/a.go": "package a\n\nimport \"example.com/lint/b\"\n\nfunc A() int { return b.B() }\n",
+		"b/b.go": "package b\n\nfunc B() int { return 1 }\n",
+	})
+	filename := filepath.Join(dir, "a", "a.go")
+	ctxt := copyContext(&build.Default)
+
+	errs, _, err := typeCheckFile(context.Background(), ctxt, filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %+v", errs)
+	}
+
+	// unsaved source of the root package
+	overlay := map[string][]byte{
+		filename: []byte("package a\n\nimport \"example.com/lint/b\"\n\nfunc A() string { return b.B() }\n"),
+	}
+	errs, _, err = typeCheckFile(context.Background(), ctxt, filename, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Row != 5 || errs[0].File != filename {
+		t.Fatalf("expected 1 error got: %+v", errs)
+	}
+
+	// changing a dependency invalidates its cached types
+	bfile := filepath.Join(dir, "b", "b.go")
+	if err := os.WriteFile(bfile, []byte("package b\n\nfunc B() string { return \"\" }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errs, _, err = typeCheckFile(context.Background(), ctxt, filename, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors after changing dependency: %+v", errs)
+	}
+
+	// adding an import reloads the package graph
+	overlay[filename] = []byte("package a\n\nimport (\n\t\"strconv\"\n\n\t\"example.com/lint/b\"\n)\n\n" +
+		"func A() int { return strconv.Itoa(len(b.B())) }\n")
+	errs, _, err = typeCheckFile(context.Background(), ctxt, filename, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Row != 9 {
+		t.Fatalf("expected 1 error got: %+v", errs)
+	}
+
+	// adding a file to a dependency reloads the package graph
+	cfile := filepath.Join(dir, "b", "c.go")
+	if err := os.WriteFile(cfile, []byte("package b\n\nfunc C() int { return 2 }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overlay[filename] = []byte("package a\n\nimport (\n\t\"strconv\"\n\n\t\"example.com/lint/b\"\n)\n\n" +
+		"func A() int { return strconv.IntSize + b.C() }\n")
+	errs, _, err = typeCheckFile(context.Background(), ctxt, filename, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors after adding a file to dependency: %+v", errs)
+	}
+}
+
+func TestTypeCheckFileSharedGraph(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a/a.go":  "package a\n\nfunc A() int { return 1 }\n",
+		"a/a2.go": "package a\n\nfunc A2() int { return A() }\n",
+	})
+	ctxt := copyContext(&build.Default)
+	for _, name := range []string{"a.go", "a2.go"} {
+		errs, _, err := typeCheckFile(context.Background(), ctxt, filepath.Join(dir, "a", name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != 0 {
+			t.Fatalf("%s: unexpected errors: %+v", name, errs)
+		}
+	}
+	n := 0
+	typeCheckGraphs.Range(func(key lru.Key, _ interface{}) bool {
+		if strings.Contains(key.(string), filepath.Join(dir, "a")) {
+			n++
+		}
+		return true
+	})
+	if n != 1 {
+		t.Errorf("expected files of a package to share 1 graph got: %d", n)
+	}
+}
+
+func TestCompLintTypeCheck(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"a.go": "package lint\n\nfunc A() int { return 1 }\n",
+	})
+	c := &CompLintRequest{
+		Filename:  filepath.Join(dir, "a.go"),
+		Src:       "package lint\n\nfunc A() int { return \"\" }\n",
+		TypeCheck: true,
+	}
+	v, _ := c.Call()
+	r := v.(*CompLintReport)
+	if len(r.Errors) != 1 || r.Errors[0].Row != 3 {
+		t.Errorf("expected 1 error got: %+v", r)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// VetRequest runs go vet on the package containing Filename using the same
+// build context and ".mgo_build_tags" as comp_lint.
+type VetRequest struct {
+	Filename string            `json:"filename"`
+	Src      string            `json:"src,omitempty"`
+	Overlay  map[string]string `json:"overlay,omitempty"`
+
+	// Analyzers, if set, are the only analyzers run (e.g. "printf").
+	Analyzers []string `json:"analyzers,omitempty"`
+
+	// Tests also reports the diagnostics of test files, defaults to true.
+	// The go command always vets the test variant of the package.
+	Tests bool `json:"tests"`
+}
+
+var vetAnalyzerRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func (v *VetRequest) flags() ([]string, error) {
+	var flags []string
+	for _, name := range v.Analyzers {
+		if !vetAnalyzerRe.MatchString(name) {
+			return nil, fmt.Errorf("vet: invalid analyzer name: %q", name)
+		}
+		flags = append(flags, "-"+name)
+	}
+	return flags, nil
+}
+
+func (v *VetRequest) vet(ctx context.Context) (*CompLintReport, error) {
+	src := []byte(v.Src)
+	if v.Src == "" {
+		var err error
+		if src, err = ioutil.ReadFile(v.Filename); err != nil {
+			return nil, err
+		}
+	}
+	flags, err := v.flags()
+	if err != nil {
+		return nil, err
+	}
+	c := &CompLintRequest{Filename: v.Filename, Src: v.Src, Overlay: v.Overlay}
+	ctxt := fileBuildContext(v.Filename, src)
+	r := &CompLintReport{Filename: v.Filename}
+	c.vet(ctx, r, ctxt, src, flags...)
+
+	// Files of the package are vetted as part of both the package and its
+	// test variant.
+	seen := make(map[string]bool, len(r.Errors))
+	a := r.Errors[:0]
+	for _, e := range r.Errors {
+		if !v.Tests && strings.HasSuffix(e.File, "_test.go") {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d:%d:%s:%s", e.File, e.Row, e.Col, e.Analyzer, e.Message)
+		if !seen[key] {
+			seen[key] = true
+			a = append(a, e)
+		}
+	}
+	r.Errors = a
+	sort.SliceStable(r.Errors, func(i, j int) bool {
+		e1, e2 := &r.Errors[i], &r.Errors[j]
+		if e1.File != e2.File {
+			return e1.File < e2.File
+		}
+		if e1.Row != e2.Row {
+			return e1.Row < e2.Row
+		}
+		return e1.Col < e2.Col
+	})
+	return r, nil
+}
+
+func (v *VetRequest) Call() (interface{}, string) {
+	if v.Filename == "" {
+		return &CompLintReport{}, "vet: missing filename"
+	}
+	v.Filename = filepath.Clean(v.Filename)
+	start := time.Now()
+	r, err := v.vet(context.Background())
+	if err != nil {
+		return &CompLintReport{Filename: v.Filename, CmdError: err.Error()}, err.Error()
+	}
+	logger.Named("vet").Debug("vet", zap.String("filename", v.Filename),
+		zap.Int("diagnostics", len(r.Errors)), zap.Duration("duration", time.Since(start)))
+	return r, ""
+}
+
+func init() {
+	registry.Register("vet", func(_ *Broker) Caller {
+		return &VetRequest{Tests: true}
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestVet(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		".mgo_build_tags": "extra\n",
+		"a.go":            "package lint\n\nimport \"fmt\"\n\nfunc A() { fmt.Printf(\"%d\", \"a\") }\n",
+		"b.go":            "//go:build extra\n\npackage lint\n\nfunc B(x int) int {\n\tx = x\n\treturn x\n}\n",
+		"a_test.go":       "package lint\n\nimport (\n\t\"fmt\"\n\t\"testing\"\n)\n\nfunc TestA(t *testing.T) { fmt.Printf(\"%s\", 1) }\n",
+	})
+	filename := filepath.Join(dir, "a.go")
+
+	vet := func(v *VetRequest) map[string]string {
+		t.Helper()
+		r, err := v.vet(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.CmdError != "" && len(r.Errors) == 0 {
+			t.Fatalf("vet failed: %s", r.CmdError)
+		}
+		m := make(map[string]string)
+		for _, e := range r.Errors {
+			if e.Category != CategoryVet {
+				t.Errorf("unexpected category: %+v", e)
+			}
+			if _, ok := m[filepath.Base(e.File)]; ok {
+				t.Errorf("duplicate diagnostic: %+v", e)
+			}
+			m[filepath.Base(e.File)] = e.Analyzer
+		}
+		return m
+	}
+
+	got := vet(&VetRequest{Filename: filename, Tests: true})
+	want := map[string]string{"a.go": "printf", "b.go": "assign", "a_test.go": "printf"}
+	if len(got) != len(want) {
+		t.Fatalf("got: %v want: %v", got, want)
+	}
+	for name, analyzer := range want {
+		if got[name] != analyzer {
+			t.Errorf("%s: analyzer = %q want: %q", name, got[name], analyzer)
+		}
+	}
+
+	got = vet(&VetRequest{Filename: filename, Tests: false, Analyzers: []string{"printf"}})
+	if len(got) != 1 || got["a.go"] != "printf" {
+		t.Errorf("expected only a.go printf diagnostic got: %v", got)
+	}
+
+	if _, err := (&VetRequest{Filename: filename, Analyzers: []string{"-bad"}}).vet(context.Background()); err == nil {
+		t.Error("expected error for invalid analyzer name")
+	}
+}